@@ -0,0 +1,576 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/lestrrat-go/hsup"
+	"github.com/lestrrat-go/hsup/ext"
+	"github.com/lestrrat-go/hsup/internal/genutil"
+	"github.com/lestrrat-go/hsup/internal/parser"
+	"github.com/lestrrat-go/hsup/internal/typegen"
+	"github.com/lestrrat-go/jshschema"
+	"github.com/pkg/errors"
+)
+
+type Builder struct {
+	AppPkg    string
+	ClientPkg string
+	Dir       string
+	GoVersion string
+	Overwrite bool
+	PkgPath   string
+}
+
+type genctx struct {
+	*parser.Result
+	AppPkg    string
+	ClientPkg string
+	Commands  map[string]string
+	Dir       string
+	GoVersion string
+	Imports   []string
+	Links     map[string]*hschema.Link
+	Overwrite bool
+	PkgPath   string
+}
+
+type options struct {
+}
+
+func Process(opts hsup.Options) error {
+	var localopts options
+	if _, err := flags.ParseArgs(&localopts, opts.Args); err != nil {
+		return errors.Wrap(err, "failed to parse command line arguments")
+	}
+
+	b := New()
+	b.Dir = opts.Dir
+	b.AppPkg = opts.AppPkg
+	b.GoVersion = opts.GoVersion
+	b.PkgPath = opts.PkgPath
+	b.Overwrite = opts.Overwrite
+	if err := b.ProcessFile(opts.Schema); err != nil {
+		return err
+	}
+	return nil
+}
+
+func New() *Builder {
+	return &Builder{
+		AppPkg:    "app",
+		ClientPkg: "client",
+		GoVersion: "1.7",
+		Overwrite: false,
+	}
+}
+
+func (b *Builder) ProcessFile(f string) error {
+	log.Printf(" ===> Using schema file '%s'", f)
+	s, err := hschema.ReadFile(f)
+	if err != nil {
+		return err
+	}
+	return b.Process(s)
+}
+
+func (b *Builder) Process(s *hschema.HyperSchema) error {
+	if b.PkgPath == "" {
+		return errors.New("PkgPath cannot be empty")
+	}
+
+	ctx := genctx{
+		AppPkg:    b.AppPkg,
+		ClientPkg: b.ClientPkg,
+		Commands:  make(map[string]string),
+		Dir:       b.Dir,
+		GoVersion: b.GoVersion,
+		Links:     make(map[string]*hschema.Link),
+		Overwrite: b.Overwrite,
+		PkgPath:   b.PkgPath,
+	}
+
+	if err := parse(&ctx, s); err != nil {
+		return err
+	}
+
+	if err := generateFiles(&ctx); err != nil {
+		return err
+	}
+
+	log.Printf(" <=== All files generated")
+	return nil
+}
+
+// parseExtras grabs the imports from hsup.client, as the CLI refers to
+// the same types as the client
+func parseExtras(ctx *genctx, s *hschema.HyperSchema) error {
+	v, ok := s.Extras["hsup.client"]
+	if !ok {
+		return nil
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return errors.New("invalid value type for hsup.client: expected map[string]interface{}")
+	}
+
+	l, ok := m["imports"]
+	if !ok {
+		return nil
+	}
+	list, ok := l.([]interface{})
+	if !ok {
+		return errors.New("invalid value type for imports: expected []interface{}")
+	}
+	for _, n := range list {
+		pkg, ok := n.(string)
+		if !ok {
+			return errors.New("invalid value type for elements in imports: expected string")
+		}
+		ctx.Imports = append(ctx.Imports, pkg)
+	}
+	return nil
+}
+
+func parse(ctx *genctx, s *hschema.HyperSchema) error {
+	pres, err := parser.Parse(s)
+	if err != nil {
+		return err
+	}
+	ctx.Result = pres
+
+	if err := parseExtras(ctx, s); err != nil {
+		return err
+	}
+
+	for _, link := range s.Links {
+		methodName := genutil.TitleToName(link.Title)
+		cmd, err := makeCommand(ctx, methodName, link)
+		if err != nil {
+			return errors.Wrapf(err, "failed to generate command for link '%s'", link.Title)
+		}
+		ctx.Commands[methodName] = cmd
+		ctx.Links[methodName] = link
+	}
+
+	sort.Strings(ctx.MethodNames)
+	return nil
+}
+
+var nonalnumrx = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// flagField is a property of the request payload that can be specified
+// as a command line flag
+type flagField struct {
+	*typegen.Field
+	base  string // type of the flag, minus the "[]" for arrays
+	slice bool
+}
+
+// flagFields returns the properties of the request payload type that
+// can be specified as flags. Only generated struct types have properties,
+// and only those of basic types (and enums, and arrays of those) are
+// used. Everything else must be specified via --data
+func flagFields(ctx *genctx, intype string, reserved map[string]struct{}) []*flagField {
+	t, ok := ctx.GeneratedType(intype)
+	if !ok {
+		return nil
+	}
+	// Defined types such as "type FooRequest Foo" have the same fields
+	// as the underlying struct
+	for ok && t.Kind == typegen.KindDefined {
+		t, ok = ctx.Types.Lookup(t.Underlying)
+	}
+	if !ok || t.Kind != typegen.KindStruct {
+		return nil
+	}
+
+	var list []*flagField
+	for _, f := range t.Fields {
+		if _, ok := reserved[f.JSONName]; ok || f.Formatted {
+			continue
+		}
+
+		elem := strings.TrimPrefix(f.Type, "[]")
+		var base string
+		switch elem {
+		case "string", "int64", "float64", "bool":
+			base = elem
+		default:
+			et, ok := ctx.Types.Lookup(elem)
+			if !ok || et.Kind != typegen.KindEnum {
+				continue
+			}
+			base = et.Underlying
+		}
+		list = append(list, &flagField{Field: f, base: base, slice: elem != f.Type})
+	}
+	return list
+}
+
+// commandName returns the name of the command struct for the link
+func commandName(name string) string {
+	return strings.ToLower(name[:1]) + name[1:] + "Command"
+}
+
+func makeCommand(ctx *genctx, name string, l *hschema.Link) (string, error) {
+	intype := ""
+	if l.Schema != nil {
+		intype = "interface{}"
+		if t, ok := ctx.RequestPayloadType[name]; ok {
+			intype = t
+		}
+	}
+	method := ctx.Routes[name].Method
+	hasResult := l.TargetSchema != nil && method != "HEAD"
+	_, multipart := l.Extras[ext.MultipartFilesKey]
+
+	// Path parameters, --data and --file are always available, so
+	// properties with the same names are only settable via --data
+	reserved := map[string]struct{}{"data": {}}
+	if multipart {
+		reserved["file"] = struct{}{}
+	}
+	params := ctx.PathParams[name]
+	for _, p := range params {
+		reserved[p.Name] = struct{}{}
+	}
+
+	var fields []*flagField
+	if intype != "" {
+		fields = flagFields(ctx, intype, reserved)
+	}
+
+	cmdName := commandName(name)
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "type %s struct {", cmdName)
+	for _, p := range params {
+		fmt.Fprintf(&buf, "\n%s %s `long:%s required:\"true\" description:%s`", genutil.CamelCase(p.Name), p.Type, strconv.Quote(p.Name), strconv.Quote("path parameter "+p.Name))
+	}
+	for _, f := range fields {
+		typ := "*" + f.base
+		if f.slice {
+			typ = "[]" + f.base
+		}
+		fmt.Fprintf(&buf, "\n%s %s `long:%s", f.Name, typ, strconv.Quote(f.JSONName))
+		if f.Description != "" {
+			fmt.Fprintf(&buf, " description:%s", strconv.Quote(f.Description))
+		}
+		buf.WriteString("`")
+	}
+	if intype != "" {
+		buf.WriteString("\nData string `long:\"data\" description:\"Request payload as JSON, or @file to read it from a file (@- for stdin)\"`")
+	}
+	if multipart {
+		buf.WriteString("\nFiles map[string]string `long:\"file\" description:\"File to upload, as field:path\"`")
+	}
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\nfunc (cmd *%s) Execute(args []string) error {", cmdName)
+	buf.WriteString("\ncl := newClient()")
+	if intype != "" {
+		if genutil.LooksLikeStruct(intype) {
+			fmt.Fprintf(&buf, "\nin := &%s{}", intype)
+		} else {
+			fmt.Fprintf(&buf, "\nvar in %s", intype)
+		}
+		buf.WriteString("\nif err := readData(cmd.Data, ")
+		if !genutil.LooksLikeStruct(intype) {
+			buf.WriteString("&")
+		}
+		buf.WriteString("in); err != nil {")
+		buf.WriteString("\nreturn err")
+		buf.WriteString("\n}")
+	}
+
+	// Flags override whatever was specified via --data
+	pkgPrefix := ctx.TransportNs + "."
+	for _, f := range fields {
+		elem := strings.TrimPrefix(f.Type, "[]")
+		conv := "x"
+		if elem != f.base {
+			conv = pkgPrefix + elem + "(x)"
+		}
+		if f.slice {
+			fmt.Fprintf(&buf, "\nif cmd.%s != nil {", f.Name)
+			fmt.Fprintf(&buf, "\nin.%s = nil", f.Name)
+			fmt.Fprintf(&buf, "\nfor _, x := range cmd.%s {", f.Name)
+			fmt.Fprintf(&buf, "\nin.%s = append(in.%s, %s)", f.Name, f.Name, conv)
+			buf.WriteString("\n}")
+			buf.WriteString("\n}")
+			continue
+		}
+		fmt.Fprintf(&buf, "\nif cmd.%s != nil {", f.Name)
+		fmt.Fprintf(&buf, "\nx := *cmd.%s", f.Name)
+		if f.Pointer {
+			if elem != f.base {
+				fmt.Fprintf(&buf, "\nv := %s", conv)
+				conv = "v"
+			}
+			fmt.Fprintf(&buf, "\nin.%s = &%s", f.Name, conv)
+		} else {
+			fmt.Fprintf(&buf, "\nin.%s = %s", f.Name, conv)
+		}
+		buf.WriteString("\n}")
+	}
+
+	buf.WriteString("\n")
+	if hasResult {
+		buf.WriteString("\nres, err := ")
+	} else {
+		buf.WriteString("\nerr := ")
+	}
+	buf.WriteString("cl." + name)
+	if multipart {
+		buf.WriteString("FromPaths")
+	}
+	buf.WriteString("(context.Background()")
+	for _, p := range params {
+		fmt.Fprintf(&buf, ", cmd.%s", genutil.CamelCase(p.Name))
+	}
+	if intype != "" {
+		buf.WriteString(", in")
+	}
+	if multipart {
+		buf.WriteString(", cmd.Files")
+	}
+	buf.WriteString(")")
+	buf.WriteString("\nif err != nil {")
+	buf.WriteString("\nreturn err")
+	buf.WriteString("\n}")
+	switch _, stream := ctx.Stream[name]; {
+	case hasResult && stream:
+		// Elements are printed one per line as they arrive
+		buf.WriteString("\ndefer res.Close()")
+		buf.WriteString("\nfor {")
+		buf.WriteString("\nv, err := res.Recv()")
+		buf.WriteString("\nif err == io.EOF {")
+		buf.WriteString("\nreturn nil")
+		buf.WriteString("\n}")
+		buf.WriteString("\nif err != nil {")
+		buf.WriteString("\nreturn err")
+		buf.WriteString("\n}")
+		buf.WriteString("\nbuf, err := json.Marshal(v)")
+		buf.WriteString("\nif err != nil {")
+		buf.WriteString("\nreturn errors.Wrap(err, `failed to encode response`)")
+		buf.WriteString("\n}")
+		buf.WriteString("\nfmt.Printf(\"%s\\n\", buf)")
+		buf.WriteString("\n}")
+	case hasResult:
+		buf.WriteString("\nreturn printJSON(res)")
+	default:
+		buf.WriteString("\nreturn nil")
+	}
+	buf.WriteString("\n}")
+
+	return buf.String(), nil
+}
+
+func generateFile(ctx *genctx, fn string, cb func(io.Writer, *genctx) error) error {
+	if _, err := os.Stat(fn); err == nil {
+		if !ctx.Overwrite {
+			log.Printf(" - File '%s' already exists. Skipping", fn)
+			return nil
+		}
+		log.Printf(" * File '%s' already exists. Overwriting", fn)
+	}
+
+	log.Printf(" + Generating file '%s'", fn)
+	f, err := genutil.CreateFile(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return cb(f, ctx)
+}
+
+func generateFiles(ctx *genctx) error {
+	name := ctx.AppPkg + "-cli"
+	fn := filepath.Join(ctx.Dir, "cmd", name, name+".go")
+	if err := generateFile(ctx, fn, generateCLICode); err != nil {
+		return err
+	}
+	return nil
+}
+
+// transportImport returns the import path of the package holding the
+// generated payload types, if it needs to be imported
+func transportImport(ctx *genctx) string {
+	if ctx.Types.Len() == 0 {
+		return ""
+	}
+
+	for _, pkg := range ctx.Imports {
+		if path.Base(pkg) == ctx.TransportNs {
+			return ""
+		}
+	}
+
+	if ctx.TransportNs == ctx.AppPkg {
+		return ctx.PkgPath
+	}
+	return path.Join(ctx.PkgPath, ctx.TransportNs)
+}
+
+func generateCLICode(out io.Writer, ctx *genctx) error {
+	buf := bytes.Buffer{}
+
+	genutil.WriteDoNotEdit(&buf)
+	buf.WriteString("package main\n\n")
+
+	imports := []string{path.Join(ctx.PkgPath, ctx.ClientPkg), "github.com/jessevdk/go-flags", "github.com/pkg/errors"}
+	if genutil.VersionCompare(ctx.GoVersion, "1.7") >= 0 {
+		imports = append(imports, "context")
+	} else {
+		imports = append(imports, "golang.org/x/net/context")
+	}
+	// The payload types are only referred to by the request payloads
+	for _, t := range ctx.RequestPayloadType {
+		if !strings.HasPrefix(t, ctx.TransportNs+".") {
+			continue
+		}
+		if pkg := transportImport(ctx); pkg != "" {
+			imports = append(imports, pkg)
+		}
+		break
+	}
+	imports = append(imports, ctx.Imports...)
+
+	stdlibs := []string{"encoding/json", "fmt", "io/ioutil", "os", "strings", "time"}
+	if len(ctx.Stream) > 0 {
+		stdlibs = append(stdlibs, "io")
+	}
+	genutil.WriteImports(&buf, stdlibs, imports)
+
+	// Environment variables are prefixed with the application name,
+	// e.g. MYAPP_ENDPOINT
+	envPrefix := strings.ToUpper(nonalnumrx.ReplaceAllString(ctx.AppPkg, "_")) + "_"
+
+	buf.WriteString(`
+var _ = context.Background
+
+type options struct {
+	Endpoint string        ` + "`" + `short:"e" long:"endpoint" env:"` + envPrefix + `ENDPOINT" required:"true" description:"Base URL of the API"` + "`" + `
+	Username string        ` + "`" + `short:"u" long:"username" env:"` + envPrefix + `USERNAME" description:"User name for basic authentication"` + "`" + `
+	Password string        ` + "`" + `short:"p" long:"password" env:"` + envPrefix + `PASSWORD" description:"Password for basic authentication"` + "`" + `
+	Token    string        ` + "`" + `short:"t" long:"token" env:"` + envPrefix + `TOKEN" description:"Bearer token"` + "`" + `
+	Timeout  time.Duration ` + "`" + `long:"timeout" description:"Time limit for the request"` + "`" + `
+}
+
+var opts options
+
+func newClient() *client.Client {
+	var options []client.Option
+	if opts.Token != "" {
+		options = append(options, client.WithBearerToken(opts.Token))
+	}
+	if opts.Timeout > 0 {
+		options = append(options, client.WithTimeout(opts.Timeout))
+	}
+	cl := client.New(opts.Endpoint, options...)
+	if opts.Username != "" {
+		cl.SetAuth(opts.Username, opts.Password)
+	}
+	return cl
+}
+
+// readData decodes the request payload specified via --data into v.
+// The payload is read from a file if it starts with "@", or from the
+// standard input if it is "@-"
+func readData(data string, v interface{}) error {
+	if data == "" {
+		return nil
+	}
+
+	var buf []byte
+	switch {
+	case data == "@-":
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return errors.Wrap(err, ` + "`failed to read payload from stdin`" + `)
+		}
+		buf = b
+	case strings.HasPrefix(data, "@"):
+		b, err := ioutil.ReadFile(data[1:])
+		if err != nil {
+			return errors.Wrap(err, ` + "`failed to read payload`" + `)
+		}
+		buf = b
+	default:
+		buf = []byte(data)
+	}
+
+	if err := json.Unmarshal(buf, v); err != nil {
+		return errors.Wrap(err, ` + "`failed to decode payload`" + `)
+	}
+	return nil
+}
+
+func printJSON(v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, ` + "`failed to encode response`" + `)
+	}
+	fmt.Printf("%s\n", buf)
+	return nil
+}
+
+// printError prints the error to the standard error. For errors
+// returned by the server, the error document is printed as well
+func printError(err error) {
+	if apiErr, ok := errors.Cause(err).(*client.APIError); ok {
+		fmt.Fprintf(os.Stderr, "%s\n", apiErr.Status)
+		if apiErr.ErrJSON != nil {
+			if buf, err := json.MarshalIndent(apiErr.ErrJSON, "", "  "); err == nil {
+				fmt.Fprintf(os.Stderr, "%s\n", buf)
+				return
+			}
+		}
+		if len(apiErr.Body) > 0 {
+			fmt.Fprintf(os.Stderr, "%s\n", apiErr.Body)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+}
+
+`)
+
+	buf.WriteString("\nfunc main() {")
+	buf.WriteString("\np := flags.NewParser(&opts, flags.HelpFlag|flags.PassDoubleDash)")
+	for _, name := range ctx.MethodNames {
+		short := ctx.Links[name].Title
+		long := ctx.Routes[name].Method + " " + ctx.Routes[name].Path
+		fmt.Fprintf(&buf, "\nif _, err := p.AddCommand(%s, %s, %s, &%s{}); err != nil {", strconv.Quote(name), strconv.Quote(short), strconv.Quote(long), commandName(name))
+		buf.WriteString("\npanic(err)")
+		buf.WriteString("\n}")
+	}
+	buf.WriteString("\nif _, err := p.Parse(); err != nil {")
+	buf.WriteString("\nif ferr, ok := err.(*flags.Error); ok && ferr.Type == flags.ErrHelp {")
+	buf.WriteString("\nfmt.Println(ferr.Message)")
+	buf.WriteString("\nreturn")
+	buf.WriteString("\n}")
+	buf.WriteString("\nprintError(err)")
+	buf.WriteString("\nos.Exit(1)")
+	buf.WriteString("\n}")
+	buf.WriteString("\n}")
+
+	for _, name := range ctx.MethodNames {
+		buf.WriteString("\n\n")
+		buf.WriteString(ctx.Commands[name])
+	}
+	buf.WriteString("\n")
+
+	return genutil.WriteFmtCode(out, &buf)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/lestrrat-go/hsup"
+	"github.com/lestrrat-go/hsup/cli"
 	"github.com/lestrrat-go/hsup/httpclient"
 	"github.com/lestrrat-go/hsup/nethttp"
 	"github.com/lestrrat-go/hsup/validator"
@@ -25,6 +29,7 @@ func main() {
 func _main() error {
 	// Remove every option that is prefixed
 	prefixes := map[string][]string{
+		"cli": nil,
 		"nethttp": nil,
 		"httpclient": nil,
 		"validator": nil,
@@ -65,26 +70,27 @@ OUTER:
 		return errors.Wrap(err, "failed to parse arguments")
 	}
 
-	// opts.Dir better be under GOPATH
-	for _, path := range strings.Split(os.Getenv("GOPATH"), string([]rune{filepath.ListSeparator})) {
-		path, err := filepath.Abs(path)
-		if err != nil {
-			return errors.Wrap(err, "failed to get absolute path")
-		}
-		path = filepath.Join(path, "src")
+	if opts.PkgPath == "" {
 		dir, err := filepath.Abs(opts.Dir)
 		if err != nil {
 			return errors.Wrap(err, "failed to get absolute dir")
 		}
 
-		if strings.HasPrefix(dir, path) {
-			opts.PkgPath = strings.TrimPrefix(strings.TrimPrefix(dir, path), string([]rune{filepath.Separator}))
-			break
+		pkgpath, err := pkgPathFromModule(dir)
+		if err != nil {
+			return errors.Wrap(err, "failed to deduce package path from go.mod")
 		}
+		if pkgpath == "" {
+			pkgpath, err = pkgPathFromGOPATH(dir)
+			if err != nil {
+				return errors.Wrap(err, "failed to deduce package path from GOPATH")
+			}
+		}
+		opts.PkgPath = pkgpath
 	}
 
 	if opts.PkgPath == "" {
-		return errors.New("target path should be under GOPATH")
+		return errors.New("could not deduce the package path: target path should be in a Go module or under GOPATH, or --pkgpath must be specified")
 	}
 
 	// Unless otherwise specified, last portion of the PkgPath is
@@ -97,6 +103,8 @@ OUTER:
 	for _, f := range opts.Flavor {
 		log.Printf(" ===> running flavor '%s'", f)
 		switch f {
+		case "cli":
+			cb = cli.Process
 		case "nethttp":
 			cb = nethttp.Process
 		case "httpclient":
@@ -114,3 +122,73 @@ OUTER:
 	}
 	return nil
 }
+
+// pkgPathFromModule locates the go.mod file closest to dir, and returns
+// the import path for dir based on the module path. The empty string is
+// returned if there is no go.mod file. dir need not exist yet
+func pkgPathFromModule(dir string) (string, error) {
+	for root := dir; ; {
+		data, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+		if err == nil {
+			modpath := modulePath(data)
+			if modpath == "" {
+				return "", errors.New("no module directive found in " + filepath.Join(root, "go.mod"))
+			}
+
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to get relative path")
+			}
+			return path.Join(modpath, filepath.ToSlash(rel)), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errors.Wrap(err, "failed to read go.mod")
+		}
+
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", nil
+		}
+		root = parent
+	}
+}
+
+// modulePath returns the module path from the contents of a go.mod file
+func modulePath(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.Index(line, "//"); i > -1 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+
+		modpath := fields[1]
+		if unquoted, err := strconv.Unquote(modpath); err == nil {
+			modpath = unquoted
+		}
+		return modpath
+	}
+	return ""
+}
+
+// pkgPathFromGOPATH returns the import path for dir if it is under one
+// of the GOPATH entries, or the empty string otherwise
+func pkgPathFromGOPATH(dir string) (string, error) {
+	for _, p := range filepath.SplitList(os.Getenv("GOPATH")) {
+		if p == "" {
+			continue
+		}
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to get absolute path")
+		}
+		p = filepath.Join(p, "src")
+
+		if strings.HasPrefix(dir, p + string([]rune{filepath.Separator})) {
+			return filepath.ToSlash(strings.TrimPrefix(dir, p + string([]rune{filepath.Separator}))), nil
+		}
+	}
+	return "", nil
+}
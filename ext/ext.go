@@ -3,8 +3,14 @@ package ext
 const (
 	ClientMutateRequestKey = "hsup.client.mutate_request"
 	CORSKey                = "hsup.cors"
+	DiscriminatorKey       = "hsup.discriminator"
+	FormatsKey             = "hsup.formats"
 	MiddlewareKey          = "hsup.middlewares"
 	MultipartFilesKey      = "hsup.multipartFiles"
+	PaginationKey          = "hsup.pagination"
+	RetryKey               = "hsup.retry"
+	StreamKey              = "hsup.stream"
+	SuccessStatusKey       = "hsup.successStatus"
 	TypeKey                = "hsup.type"
 	TransportNsKey         = "hsup.transport_ns"
 	WrapperKey             = "hsup.wrapper"
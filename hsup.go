@@ -16,8 +16,8 @@ type Processor interface {
 }
 
 type Options struct {
-	Dir       string `short:"d" long:"dir" required:"true" description:"Directory to place all files under"`
-	PkgPath   string
+	Dir       string   `short:"d" long:"dir" required:"true" description:"Directory to place all files under"`
+	PkgPath   string   `long:"pkgpath" description:"Import path of the package generated in --dir (deduced from go.mod, or GOPATH, if not specified)"`
 	AppPkg    string   `short:"a" long:"apppkg" description:"Application package name"`
 	Schema    string   `short:"s" long:"schema" required:"true" description:"schema file to process"`
 	Flavor    []string `short:"f" long:"flavor" default:"nethttp" default:"validator" default:"httpclient" description:"what type of code to generate"`
@@ -0,0 +1,360 @@
+package hsup_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/hsup"
+	"github.com/lestrrat-go/hsup/httpclient"
+	"github.com/lestrrat-go/hsup/nethttp"
+	"github.com/lestrrat-go/hsup/validator"
+)
+
+// goMod is the go.mod for the generated code. Packages that are not
+// dependencies of hsup itself are replaced by the stubs in testdata
+const goMod = `module example.com/app
+
+require (
+	github.com/gorilla/mux v1.0.0
+	github.com/lestrrat-go/jspointer v0.0.0-20181205001929-82fadba7561c
+	github.com/lestrrat-go/jsref v0.0.0-20181205001954-1b590508f37d
+	github.com/lestrrat-go/jsschema v0.0.0-20181205002244-5c81c58ffcc3
+	github.com/lestrrat-go/jsval v0.0.0-20181205002323-20277e9befc0
+	github.com/lestrrat-go/pdebug v0.0.0-20180220043849-39f9a71bcabe
+	github.com/lestrrat-go/structinfo v0.0.0-20160308131105-f74c056fe41f
+	github.com/lestrrat-go/urlenc v1.0.0
+	github.com/pkg/errors v0.8.1
+	github.com/stretchr/testify v1.0.0
+)
+
+replace github.com/gorilla/mux => STUBS/mux
+
+replace github.com/lestrrat-go/urlenc => STUBS/urlenc
+
+replace github.com/stretchr/testify => STUBS/testify
+`
+
+// processors generate the code of each flavor
+var processors = map[string]func(hsup.Options) error{
+	"nethttp":    nethttp.Process,
+	"validator":  validator.Process,
+	"httpclient": httpclient.Process,
+}
+
+// generate generates the code of the flavors (by default, the server,
+// validators and client) for the schema into a temporary directory,
+// along with a go.mod to build them with. The caller is responsible for
+// removing the directory
+func generate(t *testing.T, schemaFile string, flavors ...string) string {
+	if len(flavors) == 0 {
+		flavors = []string{"nethttp", "validator", "httpclient"}
+	}
+
+	stubs, err := filepath.Abs(filepath.Join("testdata", "stubs"))
+	if err != nil {
+		t.Fatalf("failed to get path to stubs: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "hsup-build")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+
+	opts := hsup.Options{
+		AppPkg:    "app",
+		Dir:       dir,
+		GoVersion: "1.7",
+		Overwrite: true,
+		PkgPath:   "example.com/app",
+		Schema:    schemaFile,
+		Flavor:    flavors,
+	}
+	for _, f := range flavors {
+		if err := processors[f](opts); err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("failed to generate code for %s: %s", schemaFile, err)
+		}
+	}
+
+	mod := strings.Replace(goMod, "STUBS", filepath.ToSlash(stubs), -1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to write go.mod: %s", err)
+	}
+	sum, err := ioutil.ReadFile("go.sum")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to read go.sum: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.sum"), sum, 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to write go.sum: %s", err)
+	}
+	return dir
+}
+
+// runGo runs the go command with args in the directory of the generated
+// code
+func runGo(t *testing.T, dir string, args ...string) ([]byte, error) {
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command is not available")
+	}
+
+	cmd := exec.Command(gocmd, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+	return cmd.CombinedOutput()
+}
+
+// generateAndBuild generates the server, validators and client for the
+// schema, and makes sure that the result compiles. The packages are
+// vetted rather than built, so that the generated tests are compiled too
+func generateAndBuild(t *testing.T, schemaFile string) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not available")
+	}
+
+	dir := generate(t, schemaFile)
+	defer os.RemoveAll(dir)
+
+	// The command in cmd/app is a skeleton that is completed by the user
+	if out, err := runGo(t, dir, "vet", ".", "./client", "./client/fake", "./model", "./validator"); err != nil {
+		t.Fatalf("generated code for %s does not compile: %s\n%s", schemaFile, err, out)
+	}
+}
+
+func TestBuildMultipart(t *testing.T) {
+	generateAndBuild(t, filepath.Join("testdata", "multipart.json"))
+}
+
+func TestBuildDefinedTypes(t *testing.T) {
+	generateAndBuild(t, filepath.Join("testdata", "defined.json"))
+}
+
+func TestBuildFormats(t *testing.T) {
+	generateAndBuild(t, filepath.Join("testdata", "formats.json"))
+}
+
+// TestBuildClientOnly makes sure that the client does not depend on the
+// validator package unless it is generated as well
+func TestBuildClientOnly(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not available")
+	}
+
+	dir := generate(t, filepath.Join("testdata", "multipart.json"), "httpclient")
+	defer os.RemoveAll(dir)
+
+	if out, err := runGo(t, dir, "vet", "./client", "./client/fake", "./model"); err != nil {
+		t.Fatalf("generated client does not compile: %s\n%s", err, out)
+	}
+}
+
+// negotiateTest is run as part of the generated server package, as
+// negotiate is not exported
+const negotiateTest = `package app
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	RegisterCodec("application/msgpack", jsonCodec{})
+
+	for _, c := range []struct {
+		accept    string
+		preferred string
+		expected  string
+	}{
+		{"", "application/json", "application/json"},
+		{"application/json", "application/json", "application/json"},
+		{"application/msgpack", "application/json", "application/msgpack"},
+		{"application/msgpack, application/json", "application/json", "application/json"},
+		{"application/json, application/msgpack", "application/msgpack", "application/msgpack"},
+		{"application/msgpack, application/json;q=0.5", "application/json", "application/msgpack"},
+		{"application/msgpack;q=0.5, application/json;q=0.5", "application/json", "application/json"},
+		{"*/*", "application/json", "application/json"},
+		{"application/*", "application/json", "application/json"},
+		{"application/msgpack, */*", "application/json", "application/json"},
+		{"application/msgpack, */*;q=0.1", "application/json", "application/msgpack"},
+		{"text/*", "application/json", ""},
+		{"text/html", "application/json", ""},
+		{"application/json;q=0", "application/json", ""},
+		{"application/json;q=0, application/msgpack", "application/json", "application/msgpack"},
+		{"application/json;q=0, */*", "application/json", ""},
+		{"application/json;q=0, application/msgpack;q=0.1, */*", "application/json", "application/msgpack"},
+	} {
+		mt, codec, ok := negotiate(c.accept, c.preferred)
+		if c.expected == "" {
+			if ok {
+				t.Errorf("Accept %q, preferring %s: expected no media type, got %s", c.accept, c.preferred, mt)
+			}
+			continue
+		}
+		if !ok || codec == nil {
+			t.Errorf("Accept %q, preferring %s: expected %s, got none", c.accept, c.preferred, c.expected)
+			continue
+		}
+		if mt != c.expected {
+			t.Errorf("Accept %q, preferring %s: expected %s, got %s", c.accept, c.preferred, c.expected, mt)
+		}
+	}
+}
+`
+
+func TestNegotiate(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not available")
+	}
+
+	dir := generate(t, filepath.Join("testdata", "formats.json"))
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "negotiate_test.go"), []byte(negotiateTest), 0644); err != nil {
+		t.Fatalf("failed to write test: %s", err)
+	}
+	if out, err := runGo(t, dir, "test", "-run", "TestNegotiate", "."); err != nil {
+		t.Fatalf("negotiation test failed: %s\n%s", err, out)
+	}
+}
+
+// nilQueryTest is run as part of the generated client package, against
+// a server that accepts anything
+const nilQueryTest = `package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/app/client"
+)
+
+func TestNilQuery(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer ts.Close()
+
+	if err := client.New(ts.URL).ZetaGet(context.Background(), nil); err != nil {
+		t.Fatalf("ZetaGet with a nil payload failed: %s", err)
+	}
+	if query != "" {
+		t.Errorf("expected an empty query string, got %q", query)
+	}
+}
+`
+
+func TestNilQuery(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not available")
+	}
+
+	dir := generate(t, filepath.Join("testdata", "multipart.json"))
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "client", "nil_query_test.go"), []byte(nilQueryTest), 0644); err != nil {
+		t.Fatalf("failed to write test: %s", err)
+	}
+	if out, err := runGo(t, dir, "test", "-run", "TestNilQuery", "./client"); err != nil {
+		t.Fatalf("nil query test failed: %s\n%s", err, out)
+	}
+}
+
+// multipartMutatorTest is run as part of the generated client package.
+// No request is sent, as the mutator fails
+const multipartMutatorTest = `package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/app/client"
+)
+
+func TestMultipartMutatorError(t *testing.T) {
+	cl := client.New("http://127.0.0.1:0")
+	cl.SetMutator(func(*http.Request) error {
+		return errors.New("mutator failed")
+	})
+
+	before := runtime.NumGoroutine()
+	files := map[string]client.File{"data": {Reader: strings.NewReader("data")}}
+	if err := cl.UploadRaw(context.Background(), nil, files); err == nil {
+		t.Fatal("expected UploadRaw to fail")
+	}
+
+	// Wait for goroutines that may still be exiting
+	for i := 0; i < 50 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("expected %d goroutines, got %d", before, n)
+	}
+}
+`
+
+func TestMultipartMutatorError(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not available")
+	}
+
+	dir := generate(t, filepath.Join("testdata", "multipart.json"))
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "client", "multipart_mutator_test.go"), []byte(multipartMutatorTest), 0644); err != nil {
+		t.Fatalf("failed to write test: %s", err)
+	}
+	if out, err := runGo(t, dir, "test", "-run", "TestMultipartMutatorError", "./client"); err != nil {
+		t.Fatalf("multipart mutator test failed: %s\n%s", err, out)
+	}
+}
+
+// fakeErrorTest is run as part of the generated fake package
+const fakeErrorTest = `package fake_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"example.com/app/client/fake"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestNotConfigured(t *testing.T) {
+	err := fake.New().ZetaGet(context.Background(), nil)
+	if !errors.Is(err, fake.ErrNotConfigured) {
+		t.Errorf("expected errors.Is to find ErrNotConfigured in %v", err)
+	}
+	if pkgerrors.Cause(err) != fake.ErrNotConfigured {
+		t.Errorf("expected the cause of %v to be ErrNotConfigured", err)
+	}
+}
+`
+
+func TestFakeNotConfigured(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command is not available")
+	}
+
+	dir := generate(t, filepath.Join("testdata", "multipart.json"))
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "client", "fake", "fake_test.go"), []byte(fakeErrorTest), 0644); err != nil {
+		t.Fatalf("failed to write test: %s", err)
+	}
+	if out, err := runGo(t, dir, "test", "./client/fake"); err != nil {
+		t.Fatalf("fake test failed: %s\n%s", err, out)
+	}
+}
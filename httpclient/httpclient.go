@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -16,6 +17,7 @@ import (
 	"github.com/lestrrat-go/hsup/ext"
 	"github.com/lestrrat-go/hsup/internal/genutil"
 	"github.com/lestrrat-go/hsup/internal/parser"
+	"github.com/lestrrat-go/hsup/internal/typegen"
 	"github.com/lestrrat-go/jshschema"
 	"github.com/pkg/errors"
 )
@@ -24,8 +26,12 @@ type Builder struct {
 	AppPkg    string
 	ClientPkg string
 	Dir       string
+	GoVersion string
 	Overwrite bool
 	PkgPath   string
+	// Validate makes the client validate request payloads and stream
+	// elements, using the package generated by the validator flavor
+	Validate bool
 }
 
 type clientHints struct {
@@ -38,8 +44,58 @@ type genctx struct {
 	ClientHints clientHints
 	ClientPkg   string
 	Dir         string
+	GoVersion   string
 	Overwrite   bool
 	PkgPath     string
+	Signatures  map[string][]*signature
+	Validate    bool
+}
+
+// signature describes a generated client method, so that the API
+// interface and the fake implementation can be generated from it
+type signature struct {
+	Name     string
+	Iterator bool     // true if the method returns an iterator (Result), and no error
+	Params   []*param // parameters following ctx
+	Result   string   // type of the result, or empty if only an error is returned
+	local    bool     // true if Result is a pointer to a type declared in the client package
+}
+
+type param struct {
+	Name  string
+	Type  string
+	local bool // true if Type refers to a type declared in the client package
+}
+
+// typeIn returns the type of the parameter, as referred to from the
+// package pkg. pkg is empty for the client package itself
+func (p *param) typeIn(pkg string) string {
+	if p.local && pkg != "" {
+		return strings.Replace(p.Type, "File", pkg+".File", 1)
+	}
+	return p.Type
+}
+
+// write writes the parameters and results of the method, as they
+// appear in an interface method or function type
+func (s *signature) write(buf *bytes.Buffer, pkg string) {
+	buf.WriteString("(ctx context.Context")
+	for _, p := range s.Params {
+		fmt.Fprintf(buf, ", %s %s", p.Name, p.typeIn(pkg))
+	}
+	buf.WriteString(") ")
+	result := s.Result
+	if s.local && pkg != "" {
+		result = "*" + pkg + "." + strings.TrimPrefix(result, "*")
+	}
+	switch {
+	case s.Iterator:
+		buf.WriteString(result)
+	case result == "":
+		buf.WriteString("error")
+	default:
+		fmt.Fprintf(buf, "(%s, error)", result)
+	}
 }
 
 type options struct {
@@ -54,8 +110,14 @@ func Process(opts hsup.Options) error {
 	b := New()
 	b.Dir = opts.Dir
 	b.AppPkg = opts.AppPkg
+	b.GoVersion = opts.GoVersion
 	b.PkgPath = opts.PkgPath
 	b.Overwrite = opts.Overwrite
+	for _, f := range opts.Flavor {
+		if f == "validator" {
+			b.Validate = true
+		}
+	}
 	if err := b.ProcessFile(opts.Schema); err != nil {
 		return err
 	}
@@ -66,6 +128,7 @@ func New() *Builder {
 	return &Builder{
 		AppPkg:    "app",
 		ClientPkg: "client",
+		GoVersion: "1.7",
 		Overwrite: false,
 	}
 }
@@ -81,11 +144,14 @@ func (b *Builder) ProcessFile(f string) error {
 
 func (b *Builder) Process(s *hschema.HyperSchema) error {
 	ctx := genctx{
-		AppPkg:    b.AppPkg,
-		ClientPkg: b.ClientPkg,
-		Dir:       b.Dir,
-		Overwrite: b.Overwrite,
-		PkgPath:   b.PkgPath,
+		AppPkg:     b.AppPkg,
+		ClientPkg:  b.ClientPkg,
+		Dir:        b.Dir,
+		GoVersion:  b.GoVersion,
+		Overwrite:  b.Overwrite,
+		PkgPath:    b.PkgPath,
+		Signatures: make(map[string][]*signature),
+		Validate:   b.Validate,
 	}
 
 	if err := parse(&ctx, s); err != nil {
@@ -181,7 +247,9 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 		}
 	}
 
-	if s := l.TargetSchema; s != nil {
+	// Responses to HEAD requests never have a body
+	method := ctx.Routes[name].Method
+	if s := l.TargetSchema; s != nil && method != "HEAD" {
 		if !s.IsResolved() {
 			rs, err := s.Resolve(ctx.Schema)
 			if err != nil {
@@ -195,18 +263,29 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 		}
 	}
 
-	buf := bytes.Buffer{}
-	fmt.Fprintf(&buf, `func (c *Client) %s(`, name)
+	// args and callArgs hold the arguments following ctx, as declared
+	// and as passed along, respectively
+	var args, callArgs bytes.Buffer
+	sig := &signature{Name: name}
+	params := ctx.PathParams[name]
+	for _, p := range params {
+		fmt.Fprintf(&args, ", %s %s", p.GoName, p.Type)
+		fmt.Fprintf(&callArgs, ", %s", p.GoName)
+		sig.Params = append(sig.Params, &param{Name: p.GoName, Type: p.Type})
+	}
 	if intype != "" {
-		buf.WriteString("in ")
+		typ := intype
 		if genutil.LooksLikeStruct(intype) {
-			buf.WriteRune('*')
+			typ = "*" + intype
 		}
-		buf.WriteString(intype)
+		fmt.Fprintf(&args, ", in %s", typ)
+		callArgs.WriteString(", in")
+		sig.Params = append(sig.Params, &param{Name: "in", Type: typ})
 	}
 
 	// If this is a multipart/form-data link, we need to add the potential
-	// files. This will be specified as a map of strings
+	// files. These are specified as a map of File, which are streamed
+	// as the request body
 	var files []string
 	if extv, ok := l.Extras[ext.MultipartFilesKey]; ok {
 		listv, ok := extv.([]interface{})
@@ -221,27 +300,67 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 			}
 			files[i] = sv
 		}
-
-		if intype != "" {
-			buf.WriteString(", ")
-		}
-
-		buf.WriteString("files map[string]string")
 	}
+	multipart := files != nil || l.EncType == "multipart/form-data"
 
-	buf.WriteRune(')')
+	// Streaming links return a reader for the elements, which are
+	// described by the targetSchema
+	format, stream := ctx.Stream[name]
 
-	if outtype == "" {
-		buf.WriteString(`(err error) {`)
-	} else {
+	rettype := "(err error)"
+	if outtype != "" {
 		prefix := ""
 		if genutil.LooksLikeStruct(outtype) {
 			prefix = "*"
 		}
+		sig.Result = prefix + outtype
+		if stream {
+			sig.Result = "*" + name + "Stream"
+			sig.local = true
+		}
+		rettype = fmt.Sprintf("(ret %s, err error)", sig.Result)
+	}
+
+	buf := bytes.Buffer{}
+
+	// The file name based variant opens the files, and hands them over
+	// to the main method
+	if files != nil {
+		fmt.Fprintf(&buf, "// %sFromPaths is like %s, but uploads the files at the given paths", name, name)
+		fmt.Fprintf(&buf, "\nfunc (c *Client) %sFromPaths(ctx context.Context%s, paths map[string]string) %s {", name, args.String(), rettype)
+		buf.WriteString("\nfiles := make(map[string]File, len(paths))")
+		buf.WriteString("\nfor field, fn := range paths {")
+		buf.WriteString("\nf, err := os.Open(fn)")
+		buf.WriteString("\nif err != nil {")
+		buf.WriteString("\nreturn ")
+		if outtype != "" {
+			buf.WriteString("nil, ")
+		}
+		buf.WriteString("errors.Wrapf(err, `failed to open file for '%s'`, field)")
+		buf.WriteString("\n}")
+		buf.WriteString("\ndefer f.Close()")
+		buf.WriteString("\nfiles[field] = File{")
+		buf.WriteString("\nName: filepath.Base(fn),")
+		buf.WriteString("\nContentType: mime.TypeByExtension(filepath.Ext(fn)),")
+		buf.WriteString("\nReader: f,")
+		buf.WriteString("\n}")
+		buf.WriteString("\n}")
+		fmt.Fprintf(&buf, "\nreturn c.%s(ctx%s, files)", name, callArgs.String())
+		buf.WriteString("\n}\n\n")
 
-		fmt.Fprintf(&buf, `(ret %s%s, err error) {`, prefix, outtype)
+		args.WriteString(", files map[string]File")
+
+		pathSig := &signature{Name: name + "FromPaths", Result: sig.Result}
+		pathSig.Params = append(pathSig.Params, sig.Params...)
+		pathSig.Params = append(pathSig.Params, &param{Name: "paths", Type: "map[string]string"})
+		sig.Params = append(sig.Params, &param{Name: "files", Type: "map[string]File", local: true})
+		ctx.Signatures[name] = append(ctx.Signatures[name], sig, pathSig)
+	} else {
+		ctx.Signatures[name] = append(ctx.Signatures[name], sig)
 	}
 
+	fmt.Fprintf(&buf, `func (c *Client) %s(ctx context.Context%s) %s {`, name, args.String(), rettype)
+
 	buf.WriteString("\nif pdebug.Enabled {")
 	fmt.Fprintf(&buf, "\ng := pdebug.Marker(%s).BindError(&err)", strconv.Quote("client."+name))
 	buf.WriteString("\ndefer g.End()")
@@ -257,72 +376,154 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 	errbuf.WriteString("\n}")
 	errout := errbuf.String()
 
-	fmt.Fprintf(&buf, "\n"+`u, err := url.Parse(c.endpoint + %s)`, strconv.Quote(l.Path()))
-	buf.WriteString(errout)
+	if v, ok := ctx.RequestValidators[name]; ok && ctx.Validate && intype != "" {
+		buf.WriteString("\nif c.validate {")
+		fmt.Fprintf(&buf, "\nif err := validator.%s.Validate(in); err != nil {", v.Name)
+		buf.WriteString("\nreturn ")
+		if outtype != "" {
+			buf.WriteString("nil, ")
+		}
+		buf.WriteString("newValidationError(err)")
+		buf.WriteString("\n}")
+		buf.WriteString("\n}")
+	}
+
+	// Path parameters of types other than the basic ones are converted
+	// to text, which is what the server converts them from
+	for _, p := range ctx.PathParams[name] {
+		switch p.Type {
+		case "string", "int64", "float64", "bool":
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%sText, err := %s.MarshalText()", p.GoName, p.GoName)
+		buf.WriteString(errout)
+	}
 
-	method := strings.ToLower(l.Method)
-	if method == "" {
-		method = "get"
+	pathexpr, err := makePathExpr(ctx, name)
+	if err != nil {
+		return "", err
 	}
-	if _, ok := ctx.RequestPayloadType[name]; ok {
-		if method == "get" {
+	fmt.Fprintf(&buf, "\n"+`u, err := url.Parse(c.endpoint + %s)`, pathexpr)
+	buf.WriteString(errout)
+
+	// GET and HEAD requests send the payload as the query string,
+	// everything else sends it as the request body
+	var hasBody bool
+	if payloadType, ok := ctx.RequestPayloadType[name]; ok {
+		inQuery := method == "GET" || method == "HEAD"
+		if t, ok := ctx.GeneratedType(payloadType); ok && t.Query && inQuery {
+			// MarshalQuery has a value receiver, and in may be nil
+			buf.WriteString("\nif in != nil {")
+			buf.WriteString("\nq, err := in.MarshalQuery()")
+			buf.WriteString(errout)
+			buf.WriteString("\nu.RawQuery = q.Encode()")
+			buf.WriteString("\n}")
+		} else if inQuery {
 			buf.WriteString("\nbuf, err := urlenc.Marshal(in)")
 			buf.WriteString(errout)
 			buf.WriteString("\nu.RawQuery = string(buf)")
 		} else {
-			buf.WriteString("\nvar buf bytes.Buffer")
-			if l.EncType == "multipart/form-data" {
-				buf.WriteString("\nw := multipart.NewWriter(&buf)")
+			hasBody = true
+			if multipart {
+				// The body is streamed, and the files are only read as the
+				// request is being sent. files are specified outside of the
+				// schema, because they are not to be validated
 				buf.WriteString("\nvar jsbuf bytes.Buffer")
 				buf.WriteString("\nerr = json.NewEncoder(&jsbuf).Encode(in)")
 				buf.WriteString(errout)
-				buf.WriteString("\nw.WriteField(\"payload\", jsbuf.String())")
-
-				// files are specified outside of the schema, because they are not
-				// to be validated
-				for _, name := range files {
-					fmt.Fprintf(&buf, "\nif fn, ok := files[%s]; ok {", strconv.Quote(name))
-					fmt.Fprintf(&buf, "\nfw, err := w.CreateFormFile(%s, fn)", strconv.Quote(name))
-					buf.WriteString(errout)
-					buf.WriteString("\nf, err := os.Open(fn)")
-					buf.WriteString(errout)
-					buf.WriteString("\ndefer f.Close()")
-					buf.WriteString("\n_, err = io.Copy(fw, f)")
-					buf.WriteString(errout)
-					buf.WriteString("\n}")
+				buf.WriteString("\ngetBody, contentType := multipartBody(jsbuf.Bytes(), ")
+				if files == nil {
+					buf.WriteString("nil, nil)")
+				} else {
+					buf.WriteString("[]string{")
+					for i, name := range files {
+						if i > 0 {
+							buf.WriteString(", ")
+						}
+						buf.WriteString(strconv.Quote(name))
+					}
+					buf.WriteString("}, files)")
 				}
-				buf.WriteString("\nerr = w.Close()")
-				buf.WriteString(errout)
 			} else {
-				buf.WriteString("\n" + `err = json.NewEncoder(&buf).Encode(in)`)
+				fmt.Fprintf(&buf, "\nraw, err := c.codec(%s).Marshal(in)", strconv.Quote(ctx.RequestMediaType[name]))
+				buf.WriteString(errout)
+
+				// Retried requests need a fresh copy of the body
+				buf.WriteString("\ngetBody := func() (io.Reader, error) {")
+				buf.WriteString("\nreturn bytes.NewReader(raw), nil")
+				buf.WriteString("\n}")
+				buf.WriteString("\nbody, err := getBody()")
 				buf.WriteString(errout)
 			}
 		}
 	}
 
-	switch method {
-	case "get":
-		buf.WriteString("\nif pdebug.Enabled {")
-		fmt.Fprintf(&buf, "\npdebug.Printf(%s, u.String())", strconv.Quote("GET to %s"))
+	// The iterator requests the URL of the next page as is
+	if pg, ok := ctx.Pagination[name]; ok && pg.Style == parser.PaginationLink {
+		buf.WriteString("\nif next, ok := ctx.Value(pageURLKey{}).(*url.URL); ok {")
+		buf.WriteString("\nu = next")
 		buf.WriteString("\n}")
-		buf.WriteString("\n" + `req, err := http.NewRequest("GET", u.String(), nil)`)
+	}
+
+	buf.WriteString("\nif pdebug.Enabled {")
+	fmt.Fprintf(&buf, "\npdebug.Printf(%s, u.String())", strconv.Quote(method+" to %s"))
+	if hasBody {
+		if multipart {
+			buf.WriteString("\n" + `pdebug.Printf("%s", jsbuf.String())`)
+		} else {
+			buf.WriteString("\n" + `pdebug.Printf("%s", raw)`)
+		}
+	}
+	buf.WriteString("\n}")
+	// Multipart bodies are written by a goroutine as they are read, so
+	// they are only created right before the request is sent
+	body := "nil"
+	if hasBody && !multipart {
+		body = "body"
+	}
+	// http.NewRequestWithContext is only available since Go 1.13, and
+	// (*http.Request).WithContext since Go 1.7
+	if genutil.VersionCompare(ctx.GoVersion, "1.13") >= 0 {
+		fmt.Fprintf(&buf, "\nreq, err := http.NewRequestWithContext(ctx, %s, u.String(), %s)", strconv.Quote(method), body)
 		buf.WriteString(errout)
-	case "post":
-		buf.WriteString("\nif pdebug.Enabled {")
-		fmt.Fprintf(&buf, "\npdebug.Printf(%s, u.String())", strconv.Quote("POST to %s"))
-		buf.WriteString("\n" + `pdebug.Printf("%s", buf.String())`)
-		buf.WriteString("\n}")
-		buf.WriteString("\n" + `req, err := http.NewRequest("POST", u.String(), &buf)`)
+	} else {
+		fmt.Fprintf(&buf, "\nreq, err := http.NewRequest(%s, u.String(), %s)", strconv.Quote(method), body)
 		buf.WriteString(errout)
+		if genutil.VersionCompare(ctx.GoVersion, "1.7") >= 0 {
+			buf.WriteString("\nreq = req.WithContext(ctx)")
+		}
+	}
 
-		if l.EncType == "multipart/form-data" {
-			// Must create a multipart/form-data request
-			buf.WriteString("\nreq.Header.Set(\"Content-Type\", w.FormDataContentType())")
+	// http.NewRequest can only set GetBody for in-memory bodies. Redirects
+	// that resend the body (307 and 308) need it for multipart bodies too
+	if hasBody && multipart && genutil.VersionCompare(ctx.GoVersion, "1.8") >= 0 {
+		buf.WriteString("\nreq.GetBody = func() (io.ReadCloser, error) {")
+		buf.WriteString("\nbody, err := getBody()")
+		buf.WriteString("\nif err != nil {")
+		buf.WriteString("\nreturn nil, err")
+		buf.WriteString("\n}")
+		buf.WriteString("\nreturn readCloser(body), nil")
+		buf.WriteString("\n}")
+	}
+	if hasBody {
+		if multipart {
+			buf.WriteString("\nreq.Header.Set(\"Content-Type\", contentType)")
 		} else {
-			buf.WriteString("\n" + `req.Header.Set("Content-Type", "application/json")`)
+			fmt.Fprintf(&buf, "\nreq.Header.Set(\"Content-Type\", %s)", strconv.Quote(ctx.RequestMediaType[name]))
 		}
 	}
+	if mt, ok := ctx.ResponseMediaType[name]; ok {
+		fmt.Fprintf(&buf, "\nreq.Header.Set(\"Accept\", %s)", strconv.Quote(mt))
+	}
+	if stream {
+		accept := "application/x-ndjson"
+		if format == parser.StreamSSE {
+			accept = "text/event-stream"
+		}
+		fmt.Fprintf(&buf, "\nreq.Header.Set(\"Accept\", %s)", strconv.Quote(accept))
+	}
 
+	buf.WriteString("\nc.setDefaultHeaders(req)")
 	buf.WriteString("\n" + `if c.basicAuth.username != "" && c.basicAuth.password != "" {`)
 	buf.WriteString("\nreq.SetBasicAuth(c.basicAuth.username, c.basicAuth.password)")
 	buf.WriteString("\n}")
@@ -335,44 +536,75 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 	buf.WriteString("errors.Wrap(err, `failed to mutate request`)")
 	buf.WriteString("\n}")
 	buf.WriteString("\n}")
-	buf.WriteString("\n" + `res, err := c.client.Do(req)`)
-	buf.WriteString(errout)
 
-	buf.WriteString("\nif res.StatusCode != http.StatusOK {")
-	// If in case of an error, we should at least attempt to parse the
-	// resulting JSON
-	buf.WriteString("\nif strings.HasPrefix(strings.ToLower(res.Header.Get(`Content-Type`)), `application/json`) {")
-	buf.WriteString("\nvar errjson ErrJSON")
-	buf.WriteString("\nif err := json.NewDecoder(res.Body).Decode(&errjson); err != nil {")
-	buf.WriteString("\nreturn ")
-	if outtype != "" {
-		buf.WriteString("nil, ")
+	// Link specific mutators are applied after the global one
+	for _, m := range ctx.RequestMutators[name] {
+		fmt.Fprintf(&buf, "\nif err := %s(req); err != nil {", m)
+		buf.WriteString("\nreturn ")
+		if outtype != "" {
+			buf.WriteString("nil, ")
+		}
+		buf.WriteString("errors.Wrap(err, `failed to mutate request`)")
+		buf.WriteString("\n}")
+	}
+	if hasBody && multipart {
+		buf.WriteString("\nbody, err := getBody()")
+		buf.WriteString(errout)
+		buf.WriteString("\nreq.Body = readCloser(body)")
+	}
+	getBody := "nil"
+	if hasBody {
+		getBody = "getBody"
+	}
+	fmt.Fprintf(&buf, "\nres, err := c.do(ctx, req, %s, %t)", getBody, ctx.Retry[name])
+	buf.WriteString(errout)
+	// The body of streaming responses is closed by the caller
+	if !stream {
+		buf.WriteString("\ndefer res.Body.Close()")
 	}
-	buf.WriteString("errors.Errorf(`Invalid response: '%s'`, res.Status)")
-	buf.WriteString("\n}")
 
-	buf.WriteString("\nif len(errjson.Error) > 0 {")
-	buf.WriteString("\nreturn ")
-	if outtype != "" {
-		buf.WriteString("nil, ")
+	buf.WriteString("\ncaptureResponse(ctx, res)")
+	if list := ctx.SuccessStatus[name]; len(list) > 0 {
+		buf.WriteString("\nswitch res.StatusCode {")
+		buf.WriteString("\ncase ")
+		for i, st := range list {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(strconv.Itoa(st))
+		}
+		buf.WriteString(":")
+		buf.WriteString("\ndefault:")
+	} else {
+		buf.WriteString("\nif res.StatusCode < 200 || res.StatusCode >= 300 {")
+	}
+	if stream {
+		buf.WriteString("\ndefer res.Body.Close()")
 	}
-	buf.WriteString("errors.New(errjson.Error)")
-	buf.WriteString("\n}")
-	buf.WriteString("\n}")
 	buf.WriteString("\nreturn ")
 	if outtype != "" {
 		buf.WriteString("nil, ")
 	}
-	buf.WriteString("errors.Errorf(`Invalid response: '%s'`, res.Status)")
+	buf.WriteString("newAPIError(res)")
 	buf.WriteString("\n}")
+	if stream {
+		fmt.Fprintf(&buf, "\nreturn New%sStream(res.Body), nil", name)
+		buf.WriteString("\n}")
+		buf.WriteString("\n\n")
+		buf.WriteString(makeStreamType(ctx, name, outtype, format))
+		return buf.String(), nil
+	}
 	if outtype == "" {
 		buf.WriteString("\nreturn nil")
 	} else {
+		// Empty responses are not errors, but there is nothing to decode
+		buf.WriteString("\nif res.StatusCode == http.StatusNoContent {")
+		buf.WriteString("\nreturn nil, nil")
+		buf.WriteString("\n}")
 
 		buf.WriteString("\njsonbuf := getTransportJSONBuffer()")
 		buf.WriteString("\ndefer releaseTransportJSONBuffer(jsonbuf)")
 		buf.WriteString("\n_, err = io.Copy(jsonbuf, io.LimitReader(res.Body, MaxResponseSize))")
-		buf.WriteString("\ndefer res.Body.Close()")
 		buf.WriteString("\nif pdebug.Enabled {")
 		buf.WriteString("\nif err != nil {")
 		buf.WriteString("\n" + `pdebug.Printf("failed to read respons buffer: %s", err)`)
@@ -381,9 +613,12 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 		buf.WriteString("\n}")
 		buf.WriteString("\n}")
 		buf.WriteString(errout)
+		buf.WriteString("\nif jsonbuf.Len() == 0 {")
+		buf.WriteString("\nreturn nil, nil")
+		buf.WriteString("\n}")
 		buf.WriteString("\n\nvar payload ")
 		buf.WriteString(outtype)
-		buf.WriteString("\nerr = json.Unmarshal(jsonbuf.Bytes(), &payload)")
+		fmt.Fprintf(&buf, "\nerr = c.responseCodec(res, %s).Unmarshal(jsonbuf.Bytes(), &payload)", strconv.Quote(ctx.ResponseMediaType[name]))
 		buf.WriteString(errout)
 		buf.WriteString("\nreturn ")
 		if genutil.LooksLikeStruct(outtype) {
@@ -393,9 +628,437 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 	}
 	buf.WriteString("\n}")
 
+	if pg, ok := ctx.Pagination[name]; ok {
+		it, err := makeIterator(ctx, sig, pg)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to generate iterator for '%s'", name)
+		}
+		buf.WriteString("\n\n")
+		buf.WriteString(it)
+	}
+
+	return buf.String(), nil
+}
+
+// makeStreamType generates the <Name>Stream type, which reads the
+// elements of a streaming response
+func makeStreamType(ctx *genctx, name, elem, format string) string {
+	stName := name + "Stream"
+	ptr := ""
+	if genutil.LooksLikeStruct(elem) {
+		ptr = "*"
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "// %s reads the elements of the response to %s", stName, name)
+	fmt.Fprintf(&buf, "\ntype %s struct {", stName)
+	buf.WriteString("\nbody io.ReadCloser")
+	buf.WriteString("\nr *streamReader")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\n// New%s creates a stream reading from body, which is closed", stName)
+	buf.WriteString("\n// by Close")
+	fmt.Fprintf(&buf, "\nfunc New%s(body io.ReadCloser) *%s {", stName, stName)
+	fmt.Fprintf(&buf, "\nreturn &%s{body: body, r: newStreamReader(body, %t)}", stName, format == parser.StreamSSE)
+	buf.WriteString("\n}")
+
+	buf.WriteString("\n\n// Recv returns the next element, or io.EOF once the stream has ended")
+	fmt.Fprintf(&buf, "\nfunc (s *%s) Recv() (ret %s%s, err error) {", stName, ptr, elem)
+	buf.WriteString("\nbuf, err := s.r.next()")
+	buf.WriteString("\nif err != nil {")
+	buf.WriteString("\nreturn ret, err")
+	buf.WriteString("\n}")
+	fmt.Fprintf(&buf, "\n\nvar v %s", elem)
+	buf.WriteString("\nif err := json.Unmarshal(buf, &v); err != nil {")
+	buf.WriteString("\nreturn ret, errors.Wrap(err, `failed to decode element`)")
+	buf.WriteString("\n}")
+	if v, ok := ctx.ResponseValidators[name]; ok && ctx.Validate {
+		fmt.Fprintf(&buf, "\nif err := validator.%s.Validate(&v); err != nil {", v.Name)
+		buf.WriteString("\nreturn ret, errors.Wrap(err, `invalid element`)")
+		buf.WriteString("\n}")
+	}
+	buf.WriteString("\nreturn ")
+	if ptr != "" {
+		buf.WriteString("&")
+	}
+	buf.WriteString("v, nil")
+	buf.WriteString("\n}")
+
+	buf.WriteString("\n\n// Close closes the response body")
+	fmt.Fprintf(&buf, "\nfunc (s *%s) Close() error {", stName)
+	buf.WriteString("\nreturn s.body.Close()")
+	buf.WriteString("\n}")
+	return buf.String()
+}
+
+// structType returns the struct type that the generated type typ
+// (e.g. "model.FooRequest") refers to, following defined types such as
+// "type FooRequest Foo"
+func structType(ctx *genctx, typ string) (*typegen.Type, bool) {
+	t, ok := ctx.GeneratedType(strings.TrimPrefix(typ, "*"))
+	for ok && t.Kind == typegen.KindDefined {
+		t, ok = ctx.Types.Lookup(t.Underlying)
+	}
+	if !ok || t.Kind != typegen.KindStruct {
+		return nil, false
+	}
+	return t, true
+}
+
+func lookupField(t *typegen.Type, jsonName string) (*typegen.Field, bool) {
+	for _, f := range t.Fields {
+		if f.JSONName == jsonName {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// itemType returns the type of the items in the page, and the
+// expression to extract them from the response res
+func itemType(ctx *genctx, result string, pg *parser.Pagination) (string, string, error) {
+	var elem, expr string
+	if pg.Items != "" {
+		t, ok := structType(ctx, result)
+		if !ok {
+			return "", "", errors.New("response must be an object")
+		}
+		f, ok := lookupField(t, pg.Items)
+		if !ok || !strings.HasPrefix(f.Type, "[]") {
+			return "", "", errors.Errorf("response must have an array property '%s'", pg.Items)
+		}
+		elem = strings.TrimPrefix(f.Type, "[]")
+		expr = "res." + f.Name
+	} else {
+		t, ok := ctx.GeneratedType(strings.TrimPrefix(result, "*"))
+		for ok && t.Kind == typegen.KindDefined && !strings.HasPrefix(t.Underlying, "[]") {
+			t, ok = ctx.Types.Lookup(t.Underlying)
+		}
+		if !ok || t.Kind != typegen.KindDefined {
+			return "", "", errors.New("response must be an array, unless items is specified")
+		}
+		elem = strings.TrimPrefix(t.Underlying, "[]")
+		expr = "*res"
+	}
+
+	if _, ok := ctx.Types.Lookup(elem); ok {
+		elem = ctx.TransportNs + "." + elem
+	}
+	return elem, expr, nil
+}
+
+// zeroCheck returns an expression that is true if the field of the
+// response res is absent or empty
+func zeroCheck(f *typegen.Field) (string, error) {
+	var zero string
+	switch f.Type {
+	case "string":
+		zero = `""`
+	case "int64":
+		zero = "0"
+	default:
+		return "", errors.Errorf("property '%s' must be a string or an integer", f.JSONName)
+	}
+	if f.Pointer {
+		return fmt.Sprintf("res.%s == nil || *res.%s == %s", f.Name, f.Name, zero), nil
+	}
+	return fmt.Sprintf("res.%s == %s", f.Name, zero), nil
+}
+
+// makeIterator generates the <Name>Iterator type for a paginated link,
+// along with the <Name>All method returning it
+func makeIterator(ctx *genctx, sig *signature, pg *parser.Pagination) (string, error) {
+	if sig.Result == "" {
+		return "", errors.New("paginated links must have a targetSchema")
+	}
+
+	name := sig.Name
+	itName := name + "Iterator"
+	elem, itemsExpr, err := itemType(ctx, sig.Result, pg)
+	if err != nil {
+		return "", err
+	}
+
+	// The request payload is copied, so that the cursor can be set
+	// without modifying the caller's value
+	var in *param
+	for _, p := range sig.Params {
+		if p.Name == "in" {
+			in = p
+		}
+	}
+	copyIn := in != nil && strings.HasPrefix(in.Type, "*")
+
+	var cursorField *typegen.Field
+	if pg.Style == parser.PaginationCursor {
+		if !copyIn {
+			return "", errors.New("the cursor style requires a request payload")
+		}
+		t, ok := structType(ctx, in.Type)
+		if !ok {
+			return "", errors.New("the cursor style requires a request payload object")
+		}
+		if cursorField, ok = lookupField(t, pg.Param); !ok {
+			return "", errors.Errorf("request has no property '%s'", pg.Param)
+		}
+	}
+
+	var nextField *typegen.Field
+	if pg.Next != "" {
+		t, ok := structType(ctx, sig.Result)
+		if !ok {
+			return "", errors.New("response must be an object")
+		}
+		if nextField, ok = lookupField(t, pg.Next); !ok {
+			return "", errors.Errorf("response has no property '%s'", pg.Next)
+		}
+		if cursorField != nil && cursorField.Type != nextField.Type {
+			return "", errors.Errorf("properties '%s' and '%s' must be of the same type", pg.Param, pg.Next)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "// %s iterates over the items of every page of %s. Pages", itName, name)
+	buf.WriteString("\n// are fetched as needed")
+	fmt.Fprintf(&buf, "\ntype %s struct {", itName)
+	buf.WriteString("\napi API")
+	buf.WriteString("\nctx context.Context")
+	for _, p := range sig.Params {
+		if p == in && copyIn {
+			fmt.Fprintf(&buf, "\nin %s", strings.TrimPrefix(p.Type, "*"))
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s %s", p.Name, p.Type)
+	}
+	if pg.Style == parser.PaginationLink {
+		buf.WriteString("\nnext *url.URL")
+	}
+	fmt.Fprintf(&buf, "\nitems []%s", elem)
+	fmt.Fprintf(&buf, "\nitem %s", elem)
+	buf.WriteString("\nmore bool")
+	buf.WriteString("\nerr error")
+	buf.WriteString("\n}")
+
+	// The constructor takes an API, so that fake implementations can
+	// provide iterators too
+	fmt.Fprintf(&buf, "\n\n// New%s returns an iterator that calls api.%s for each page", itName, name)
+	fmt.Fprintf(&buf, "\nfunc New%s(ctx context.Context, api API", itName)
+	for _, p := range sig.Params {
+		fmt.Fprintf(&buf, ", %s %s", p.Name, p.Type)
+	}
+	fmt.Fprintf(&buf, ") *%s {", itName)
+	fmt.Fprintf(&buf, "\nit := &%s{", itName)
+	buf.WriteString("\napi: api,")
+	buf.WriteString("\nctx: ctx,")
+	for _, p := range sig.Params {
+		if p == in && copyIn {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%s: %s,", p.Name, p.Name)
+	}
+	buf.WriteString("\nmore: true,")
+	buf.WriteString("\n}")
+	if copyIn {
+		buf.WriteString("\nif in != nil {")
+		buf.WriteString("\nit.in = *in")
+		buf.WriteString("\n}")
+	}
+	buf.WriteString("\nreturn it")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\n// %sAll returns an iterator over the items of every page of %s", name, name)
+	fmt.Fprintf(&buf, "\nfunc (c *Client) %sAll(ctx context.Context", name)
+	for _, p := range sig.Params {
+		fmt.Fprintf(&buf, ", %s %s", p.Name, p.Type)
+	}
+	fmt.Fprintf(&buf, ") *%s {", itName)
+	fmt.Fprintf(&buf, "\nreturn New%s(ctx, c", itName)
+	for _, p := range sig.Params {
+		buf.WriteString(", " + p.Name)
+	}
+	buf.WriteString(")")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\n// Next advances the iterator to the next item, fetching the next page")
+	buf.WriteString("\n// if necessary. It returns false when there are no more items, or")
+	buf.WriteString("\n// when an error occurs")
+	fmt.Fprintf(&buf, "\nfunc (it *%s) Next() bool {", itName)
+	buf.WriteString("\nfor len(it.items) == 0 {")
+	buf.WriteString("\nif !it.more || it.err != nil {")
+	buf.WriteString("\nreturn false")
+	buf.WriteString("\n}")
+	buf.WriteString("\nit.fetch()")
+	buf.WriteString("\n}")
+	buf.WriteString("\nit.item = it.items[0]")
+	buf.WriteString("\nit.items = it.items[1:]")
+	buf.WriteString("\nreturn true")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\n// Item returns the current item")
+	fmt.Fprintf(&buf, "\nfunc (it *%s) Item() %s {", itName, elem)
+	buf.WriteString("\nreturn it.item")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\n// Err returns the error that stopped the iteration, if any")
+	fmt.Fprintf(&buf, "\nfunc (it *%s) Err() error {", itName)
+	buf.WriteString("\nreturn it.err")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(&buf, "\n\nfunc (it *%s) fetch() {", itName)
+	buf.WriteString("\nctx := it.ctx")
+	if pg.Style == parser.PaginationLink {
+		buf.WriteString("\nvar info ResponseInfo")
+		buf.WriteString("\nctx = CaptureResponse(ctx, &info)")
+		buf.WriteString("\nif it.next != nil {")
+		buf.WriteString("\nctx = context.WithValue(ctx, pageURLKey{}, it.next)")
+		buf.WriteString("\n}")
+	}
+	fmt.Fprintf(&buf, "\nres, err := it.api.%s(ctx", name)
+	for _, p := range sig.Params {
+		if p == in && copyIn {
+			buf.WriteString(", &it.in")
+			continue
+		}
+		buf.WriteString(", it." + p.Name)
+	}
+	buf.WriteString(")")
+	buf.WriteString("\nif err != nil {")
+	buf.WriteString("\nit.err = err")
+	buf.WriteString("\nreturn")
+	buf.WriteString("\n}")
+	buf.WriteString("\nif res == nil {")
+	buf.WriteString("\nit.more = false")
+	buf.WriteString("\nreturn")
+	buf.WriteString("\n}")
+	fmt.Fprintf(&buf, "\nit.items = %s", itemsExpr)
+
+	switch {
+	case pg.Style == parser.PaginationCursor:
+		check, err := zeroCheck(nextField)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "\nif %s {", check)
+		buf.WriteString("\nit.more = false")
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		next := "res." + nextField.Name
+		if nextField.Pointer {
+			next = "*" + next
+		}
+		if cursorField.Pointer {
+			fmt.Fprintf(&buf, "\nnext := %s", next)
+			fmt.Fprintf(&buf, "\nit.in.%s = &next", cursorField.Name)
+		} else {
+			fmt.Fprintf(&buf, "\nit.in.%s = %s", cursorField.Name, next)
+		}
+	case nextField != nil:
+		// The URL of the next page is in the response
+		if nextField.Type != "string" {
+			return "", errors.Errorf("property '%s' must be a string", pg.Next)
+		}
+		check, err := zeroCheck(nextField)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&buf, "\nif %s {", check)
+		buf.WriteString("\nit.more = false")
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		next := "res." + nextField.Name
+		if nextField.Pointer {
+			next = "*" + next
+		}
+		fmt.Fprintf(&buf, "\nnext, err := url.Parse(%s)", next)
+		buf.WriteString("\nif err != nil {")
+		buf.WriteString("\nit.err = errors.Wrap(err, `failed to parse URL of the next page`)")
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		buf.WriteString("\nif info.URL != nil {")
+		buf.WriteString("\nnext = info.URL.ResolveReference(next)")
+		buf.WriteString("\n}")
+		buf.WriteString("\nit.next = next")
+	default:
+		buf.WriteString("\nnext, err := nextLink(&info)")
+		buf.WriteString("\nif err != nil {")
+		buf.WriteString("\nit.err = err")
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		buf.WriteString("\nit.next = next")
+		buf.WriteString("\nit.more = next != nil")
+	}
+	buf.WriteString("\n}")
+
+	ctx.Signatures[name] = append(ctx.Signatures[name], &signature{
+		Name:     name + "All",
+		Iterator: true,
+		Params:   sig.Params,
+		Result:   "*" + itName,
+		local:    true,
+	})
 	return buf.String(), nil
 }
 
+// makePathExpr creates a Go expression that expands the URI template
+// variables in the link's path using the method's arguments
+func makePathExpr(ctx *genctx, name string) (string, error) {
+	path := ctx.Routes[name].Path
+
+	params := ctx.PathParams[name]
+	if len(params) == 0 {
+		return strconv.Quote(path), nil
+	}
+
+	var parts []string
+	for _, p := range params {
+		i := strings.Index(path, "{"+p.Name+"}")
+		if i < 0 {
+			return "", errors.Errorf("could not find URI template variable '%s' in path", p.Name)
+		}
+		if i > 0 {
+			parts = append(parts, strconv.Quote(path[:i]))
+		}
+		path = path[i+len(p.Name)+2:]
+
+		switch p.Type {
+		case "string":
+			parts = append(parts, fmt.Sprintf("url.PathEscape(%s)", p.GoName))
+		case "int64":
+			parts = append(parts, fmt.Sprintf("strconv.FormatInt(%s, 10)", p.GoName))
+		case "float64":
+			parts = append(parts, fmt.Sprintf("strconv.FormatFloat(%s, 'f', -1, 64)", p.GoName))
+		case "bool":
+			parts = append(parts, fmt.Sprintf("strconv.FormatBool(%s)", p.GoName))
+		default:
+			parts = append(parts, fmt.Sprintf("url.PathEscape(string(%sText))", p.GoName))
+		}
+	}
+	if len(path) > 0 {
+		parts = append(parts, strconv.Quote(path))
+	}
+	return strings.Join(parts, " + "), nil
+}
+
+// transportImport returns the import path of the package that holds
+// the generated payload types, or the empty string if the user already
+// imports it
+func transportImport(ctx *genctx) string {
+	if ctx.Types.Len() == 0 {
+		return ""
+	}
+
+	for _, pkg := range ctx.ClientHints.Imports {
+		if path.Base(pkg) == ctx.TransportNs {
+			return ""
+		}
+	}
+
+	if ctx.TransportNs == ctx.AppPkg {
+		return ctx.PkgPath
+	}
+	return path.Join(ctx.PkgPath, ctx.TransportNs)
+}
+
 func generateFile(ctx *genctx, fn string, cb func(io.Writer, *genctx) error) error {
 	if _, err := os.Stat(fn); err == nil {
 		if !ctx.Overwrite {
@@ -422,32 +1085,264 @@ func generateFiles(ctx *genctx) error {
 		}
 	}
 
+	{
+		fn := filepath.Join(ctx.Dir, "client", "fake", "fake.go")
+		if err := generateFile(ctx, fn, generateFakeCode); err != nil {
+			return err
+		}
+	}
+
+	// The payload types are shared with the server, but the client may
+	// be generated on its own
+	if ctx.Types.Len() > 0 {
+		fn := filepath.Join(ctx.Dir, ctx.TransportNs, "types_hsup.go")
+		if ctx.TransportNs == ctx.AppPkg {
+			fn = filepath.Join(ctx.Dir, "types_hsup.go")
+		}
+		if err := generateFile(ctx, fn, generateTypesCode); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func generateClientCode(out io.Writer, ctx *genctx) error {
+func generateTypesCode(out io.Writer, ctx *genctx) error {
+	return ctx.Types.Generate(out, ctx.TransportNs)
+}
+
+func generateFakeCode(out io.Writer, ctx *genctx) error {
+	buf := bytes.Buffer{}
+
+	genutil.WriteDoNotEdit(&buf)
+	buf.WriteString("package fake\n\n")
+
+	imports := []string{path.Join(ctx.PkgPath, "client")}
+	if genutil.VersionCompare(ctx.GoVersion, "1.7") >= 0 {
+		imports = append(imports, "context")
+	} else {
+		imports = append(imports, "golang.org/x/net/context")
+	}
+
+	// Only import the packages that the signatures refer to
+	var candidates []string
+	if pkg := transportImport(ctx); pkg != "" {
+		candidates = append(candidates, pkg)
+	}
+	candidates = append(candidates, ctx.ClientHints.Imports...)
+	for _, pkg := range candidates {
+		if signaturesRefer(ctx, path.Base(pkg)) {
+			imports = append(imports, pkg)
+		}
+	}
+
+	genutil.WriteImports(&buf, []string{"errors", "sync"}, imports)
+
+	buf.WriteString(`
+// ErrNotConfigured is returned by the methods of Client whose
+// corresponding func field is not set
+var ErrNotConfigured = errors.New("method is not configured")
+
+// notConfiguredError names the method that is not configured. It
+// unwraps to ErrNotConfigured, both for errors.Is and errors.Cause
+type notConfiguredError struct {
+	method string
+}
+
+func (e notConfiguredError) Error() string {
+	return e.method + ": " + ErrNotConfigured.Error()
+}
+
+func (e notConfiguredError) Cause() error {
+	return ErrNotConfigured
+}
+
+func (e notConfiguredError) Unwrap() error {
+	return ErrNotConfigured
+}
+
+// Call records a call made to a method of Client
+type Call struct {
+	Method string
+	Args   []interface{} // arguments following the context
+}
+
+`)
+
+	buf.WriteString("\n// Client is an in-memory implementation of client.API. Each method")
+	buf.WriteString("\n// records the call, and calls the func field of the same name")
+	buf.WriteString("\n// followed by \"Func\". Methods whose func field is not set return")
+	buf.WriteString("\n// ErrNotConfigured. Iterators fetch their pages using these methods")
+	buf.WriteString("\ntype Client struct {")
+	for _, methodName := range ctx.MethodNames {
+		for _, sig := range ctx.Signatures[methodName] {
+			if sig.Iterator {
+				continue
+			}
+			fmt.Fprintf(&buf, "\n%sFunc func", sig.Name)
+			sig.write(&buf, "client")
+		}
+	}
+	buf.WriteString("\n\nmu sync.Mutex")
+	buf.WriteString("\ncalls []Call")
+	buf.WriteString("\n}")
+	buf.WriteString("\n\nvar _ client.API = (*Client)(nil)")
+
+	buf.WriteString(`
+
+func New() *Client {
+	return &Client{}
+}
+
+func (c *Client) record(method string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the calls made so far, in order
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// CallsTo returns the calls made so far to the named method, in order
+func (c *Client) CallsTo(method string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []Call
+	for _, call := range c.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls made so far
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+`)
+
+	for _, methodName := range ctx.MethodNames {
+		for _, sig := range ctx.Signatures[methodName] {
+			fmt.Fprintf(&buf, "\n\nfunc (c *Client) %s", sig.Name)
+			sig.write(&buf, "client")
+			buf.WriteString(" {")
+
+			// Payloads are recorded as copies, as the caller (or an
+			// iterator fetching the next page) may modify them later
+			buf.WriteString("\nargs := []interface{}{")
+			for i, p := range sig.Params {
+				if i > 0 {
+					buf.WriteString(", ")
+				}
+				buf.WriteString(p.Name)
+			}
+			buf.WriteString("}")
+			for i, p := range sig.Params {
+				if !strings.HasPrefix(p.Type, "*") {
+					continue
+				}
+				fmt.Fprintf(&buf, "\nif %s != nil {", p.Name)
+				fmt.Fprintf(&buf, "\nv := *%s", p.Name)
+				fmt.Fprintf(&buf, "\nargs[%d] = &v", i)
+				buf.WriteString("\n}")
+			}
+			fmt.Fprintf(&buf, "\nc.record(%s, args...)", strconv.Quote(sig.Name))
+
+			// Iterators fetch the pages using the fake methods
+			if sig.Iterator {
+				fmt.Fprintf(&buf, "\nreturn client.New%s(ctx, c", strings.TrimPrefix(sig.Result, "*"))
+				for _, p := range sig.Params {
+					buf.WriteString(", " + p.Name)
+				}
+				buf.WriteString(")")
+				buf.WriteString("\n}")
+				continue
+			}
+			fmt.Fprintf(&buf, "\nif c.%sFunc == nil {", sig.Name)
+			buf.WriteString("\nreturn ")
+			if sig.Result != "" {
+				buf.WriteString("nil, ")
+			}
+			fmt.Fprintf(&buf, "notConfiguredError{method: %s}", strconv.Quote(sig.Name))
+			buf.WriteString("\n}")
+			fmt.Fprintf(&buf, "\nreturn c.%sFunc(ctx", sig.Name)
+			for _, p := range sig.Params {
+				buf.WriteString(", " + p.Name)
+			}
+			buf.WriteString(")")
+			buf.WriteString("\n}")
+		}
+	}
+	buf.WriteString("\n")
+
+	return genutil.WriteFmtCode(out, &buf)
+}
+
+// signaturesRefer returns true if any of the generated methods refers
+// to a type in the package pkg
+func signaturesRefer(ctx *genctx, pkg string) bool {
+	for _, list := range ctx.Signatures {
+		for _, sig := range list {
+			if strings.Contains(sig.Result, pkg+".") {
+				return true
+			}
+			for _, p := range sig.Params {
+				if strings.Contains(p.Type, pkg+".") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func generateClientCode(out io.Writer, ctx *genctx) error {
 	buf := bytes.Buffer{}
 
 	genutil.WriteDoNotEdit(&buf)
 	fmt.Fprintf(&buf, "package %s\n\n", ctx.ClientPkg)
 
 	imports := []string{"github.com/lestrrat-go/pdebug", "github.com/lestrrat-go/urlenc", "github.com/pkg/errors"}
+	if genutil.VersionCompare(ctx.GoVersion, "1.7") >= 0 {
+		imports = append(imports, "context")
+	} else {
+		imports = append(imports, "golang.org/x/net/context", "golang.org/x/net/context/ctxhttp")
+	}
+	if pkg := transportImport(ctx); pkg != "" {
+		imports = append(imports, pkg)
+	}
+	if ctx.Validate && (len(ctx.RequestValidators) > 0 || len(ctx.Stream) > 0) {
+		imports = append(imports, path.Join(ctx.PkgPath, "validator"))
+	}
 	if l := ctx.ClientHints.Imports; len(l) > 0 {
 		imports = append(imports, l...)
 	}
 
+	codecStdlibs, codecExtlibs := genutil.CodecImports(ctx.RequestMediaType, ctx.ResponseMediaType)
 	genutil.WriteImports(
 		&buf,
-		[]string{"bytes", "encoding/json", "io", "mime/multipart", "net/http", "net/url", "os", "strings", "sync"},
-		imports,
+		append([]string{"bufio", "bytes", "encoding/json", "fmt", "io", "io/ioutil", "math/rand", "mime", "mime/multipart", "net/http", "net/textproto", "net/url", "os", "path/filepath", "strconv", "strings", "sync", "time"}, codecStdlibs...),
+		append(imports, codecExtlibs...),
 	)
 
 	buf.WriteString(`
 const MaxResponseSize = (1<<20)*2
 var _ = bytes.MinRead
 var _ = json.Decoder{}
+var _ = filepath.Base
+var _ = fmt.Sprint
+var _ = mime.TypeByExtension
 var _ = multipart.Form{}
 var _ = os.Stdout
+var _ = strconv.Quote
+var _ = urlenc.Marshal
 var transportJSONBufferPool = sync.Pool{
 	New: allocTransportJSONBuffer,
 }
@@ -479,20 +1374,666 @@ func (a BasicAuth) Password() string {
 }
 
 type ErrJSON struct {
-	Error string ` + "`" + `json:"error,omitempty"` + "`" + `
+	Error   string        ` + "`" + `json:"error,omitempty"` + "`" + `
+	Details []ErrorDetail ` + "`" + `json:"details,omitempty"` + "`" + `
+}
+
+// ErrorDetail describes a single problem with the request, as reported
+// by the server
+type ErrorDetail struct {
+	Pointer string ` + "`" + `json:"pointer,omitempty"` + "`" + `
+	Message string ` + "`" + `json:"message"` + "`" + `
+}
+
+// String returns the error message, followed by the details (if any)
+func (e ErrJSON) String() string {
+	if len(e.Details) == 0 {
+		return e.Error
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(e.Error)
+	for i, d := range e.Details {
+		if i == 0 {
+			buf.WriteString(": ")
+		} else {
+			buf.WriteString(", ")
+		}
+		if d.Pointer != "" {
+			buf.WriteString(d.Pointer)
+			buf.WriteString(": ")
+		}
+		buf.WriteString(d.Message)
+	}
+	return buf.String()
+}
+
+// ResponseInfo holds the details of the response to a request
+type ResponseInfo struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	URL        *url.URL // URL of the request
+}
+
+type responseInfoKey struct{}
+
+// CaptureResponse returns a context that makes the client methods store
+// the details of the response in info, e.g.
+//
+//   var info client.ResponseInfo
+//   res, err := cl.CreateUser(client.CaptureResponse(ctx, &info), in)
+//   if info.StatusCode == http.StatusAccepted {
+//     ...
+//   }
+func CaptureResponse(ctx context.Context, info *ResponseInfo) context.Context {
+	return context.WithValue(ctx, responseInfoKey{}, info)
+}
+
+func captureResponse(ctx context.Context, res *http.Response) {
+	info, ok := ctx.Value(responseInfoKey{}).(*ResponseInfo)
+	if !ok || info == nil {
+		return
+	}
+	info.StatusCode = res.StatusCode
+	info.Status = res.Status
+	info.Header = res.Header
+	if res.Request != nil {
+		info.URL = res.Request.URL
+	}
+}
+
+// SetResponseInfo stores info in the ResponseInfo passed to
+// CaptureResponse, if any. It is meant for implementations of API other
+// than Client, such as fakes, so that callers and iterators see the
+// response details that they expect (e.g. the Link header)
+func SetResponseInfo(ctx context.Context, info ResponseInfo) {
+	if p, ok := ctx.Value(responseInfoKey{}).(*ResponseInfo); ok && p != nil {
+		*p = info
+	}
+}
+
+// pageURLKey is used by the iterators of paginated links, to make the
+// client methods request the URL of the next page instead
+type pageURLKey struct{}
+
+// PageURL returns the URL of the page that an iterator requests, or nil
+// for the first page. Like SetResponseInfo, it is meant for
+// implementations of API other than Client
+func PageURL(ctx context.Context) *url.URL {
+	u, _ := ctx.Value(pageURLKey{}).(*url.URL)
+	return u
+}
+
+// nextLink returns the URL in the Link header with rel="next", resolved
+// against the URL of the request. nil is returned if there is none
+func nextLink(info *ResponseInfo) (*url.URL, error) {
+	for _, v := range info.Header["Link"] {
+		for _, link := range strings.Split(v, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range parts[1:] {
+				p = strings.TrimSpace(p)
+				if !strings.HasPrefix(strings.ToLower(p), "rel=") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(p[4:], ` + "`\"`" + `)) {
+					if !strings.EqualFold(rel, "next") {
+						continue
+					}
+					u, err := url.Parse(target[1 : len(target)-1])
+					if err != nil {
+						return nil, errors.Wrap(err, ` + "`failed to parse URL in Link header`" + `)
+					}
+					if info.URL != nil {
+						u = info.URL.ResolveReference(u)
+					}
+					return u, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// APIError is returned by the client methods when the server responds
+// with a non-successful status code
+type APIError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte   // raw response body, up to MaxResponseSize bytes
+	ErrJSON    *ErrJSON // nil unless the body is a JSON error document
+}
+
+// newAPIError creates an *APIError from the response. If the body is
+// JSON, we should at least attempt to parse it
+func newAPIError(res *http.Response) *APIError {
+	e := &APIError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+		Header:     res.Header,
+	}
+
+	e.Body, _ = ioutil.ReadAll(io.LimitReader(res.Body, MaxResponseSize))
+	if strings.HasPrefix(strings.ToLower(res.Header.Get(` + "`Content-Type`" + `)), ` + "`application/json`" + `) {
+		var errjson ErrJSON
+		if err := json.Unmarshal(e.Body, &errjson); err == nil && len(errjson.Error) > 0 {
+			e.ErrJSON = &errjson
+		}
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	if e.ErrJSON != nil {
+		return e.ErrJSON.String()
+	}
+	return "Invalid response: '" + e.Status + "'"
+}
+
+// IsNotFound returns true if the server responded with 404 Not Found
+func (e *APIError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// IsValidation returns true if the server rejected the request payload
+// as invalid
+func (e *APIError) IsValidation() bool {
+	return e.StatusCode == http.StatusUnprocessableEntity
+}
+
+// RetryPolicy specifies how failed requests are retried. Only requests
+// for idempotent methods (and links with hsup.retry set to true) are
+// retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the
+	// first one. Values less than 2 disable retries
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. The delay is
+	// doubled for each subsequent retry, up to MaxBackoff. The actual
+	// delay is randomly chosen between half the delay and the delay,
+	// or the value of the Retry-After header, if it is longer
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// ShouldRetry decides if the request should be retried. If nil,
+	// network errors and 429, 502, 503 and 504 responses are retried
+	ShouldRetry func(*http.Response, error) bool
+}
+
+// DefaultRetryPolicy is a sensible policy for most cases
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	MinBackoff:  100 * time.Millisecond,
+	MaxBackoff:  5 * time.Second,
+}
+
+func defaultShouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry (starting at 1)
+func (p *RetryPolicy) backoff(retry int, res *http.Response) time.Duration {
+	d := p.MinBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+
+	if res != nil {
+		if ra := parseRetryAfter(res.Header.Get("Retry-After")); ra > d {
+			d = ra
+		}
+	}
+	return d
+}
+
+// parseRetryAfter parses the value of the Retry-After header, which is
+// either a number of seconds, or an HTTP date
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(v); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(time.Now())
+	}
+	return 0
 }
 
 type Client struct {
-	basicAuth BasicAuth
-	client *http.Client
-	endpoint string
-	mutator  func(*http.Request) error
+	basicAuth    BasicAuth
+	client       *http.Client
+	endpoint     string
+	headers      http.Header
+	middlewares  []func(http.RoundTripper) http.RoundTripper
+	mutator      func(*http.Request) error
+	codecs       map[string]Codec
+	retry        *RetryPolicy
+	timeout      time.Duration`)
+	if ctx.Validate {
+		buf.WriteString("\nvalidate bool")
+	}
+	buf.WriteString(`
+}
+
+// Option configures the Client created by New
+type Option func(*Client)
+
+// WithHTTPClient specifies the *http.Client used to send requests. If
+// combined with WithTimeout or WithRoundTripper, a copy of cl is
+// modified, and cl itself is left untouched
+func WithHTTPClient(cl *http.Client) Option {
+	return func(c *Client) {
+		c.client = cl
+	}
 }
 
-func New(s string) *Client {
-	return &Client{
-		client: &http.Client{},
+// WithTimeout specifies the time limit for requests, including reading
+// the response body
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithUserAgent specifies the User-Agent header sent with every request
+func WithUserAgent(ua string) Option {
+	return func(c *Client) {
+		c.headers.Set("User-Agent", ua)
+	}
+}
+
+// WithBearerToken specifies the token sent in the Authorization header
+// of every request
+func WithBearerToken(token string) Option {
+	return func(c *Client) {
+		c.headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithDefaultHeaders specifies headers sent with every request. Headers
+// set by the generated methods or by mutators take precedence
+func WithDefaultHeaders(h http.Header) Option {
+	return func(c *Client) {
+		for k, v := range h {
+			c.headers[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+		}
+	}
+}
+
+// WithRetry specifies the policy used to retry failed requests. By
+// default, requests are not retried
+func WithRetry(p RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = &p
+	}
+}
+
+`)
+	if ctx.Validate {
+		buf.WriteString(`
+// ValidationError is returned by the client methods when the request
+// payload fails validation before being sent. See WithRequestValidation
+type ValidationError struct {
+	Err     error
+	Details []ErrorDetail
+}
+
+// newValidationError creates a *ValidationError from the error returned
+// by the validator
+func newValidationError(err error) *ValidationError {
+	return &ValidationError{
+		Err:     err,
+		Details: validationDetails(err),
+	}
+}
+
+func (e *ValidationError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString("invalid request payload")
+	for i, d := range e.Details {
+		if i == 0 {
+			buf.WriteString(": ")
+		} else {
+			buf.WriteString(", ")
+		}
+		if d.Pointer != "" {
+			buf.WriteString(d.Pointer)
+			buf.WriteString(" ")
+		}
+		buf.WriteString(d.Message)
+	}
+	return buf.String()
+}
+
+func (e *ValidationError) Cause() error {
+	return e.Err
+}
+
+// WithRequestValidation makes the client validate the request payloads
+// against the schema, and return a *ValidationError instead of sending
+// invalid requests
+func WithRequestValidation() Option {
+	return func(c *Client) {
+		c.validate = true
+	}
+}
+`)
+	}
+	buf.WriteString(`
+// WithCodec registers c as the codec for the media type mt, which
+// replaces any existing codec for mt. Codecs are selected by the
+// encType and mediaType of each link, and by the Content-Type of the
+// responses
+func WithCodec(mt string, codec Codec) Option {
+	return func(c *Client) {
+		c.codecs[strings.ToLower(mt)] = codec
+	}
+}
+
+// WithRoundTripper wraps the transport of the *http.Client with mw.
+// When specified multiple times, the first one specified is the
+// outermost, i.e. sees the request first
+func WithRoundTripper(mw func(http.RoundTripper) http.RoundTripper) Option {
+	return func(c *Client) {
+		c.middlewares = append(c.middlewares, mw)
+	}
+}
+
+func New(s string, options ...Option) *Client {
+	c := &Client{
+		client:   &http.Client{},
+		codecs:   defaultCodecs(),
 		endpoint: s,
+		headers:  http.Header{},
+	}
+	for _, o := range options {
+		o(c)
+	}
+
+	if c.timeout > 0 || len(c.middlewares) > 0 {
+		cl := *c.client
+		if c.timeout > 0 {
+			cl.Timeout = c.timeout
+		}
+		if len(c.middlewares) > 0 {
+			rt := cl.Transport
+			if rt == nil {
+				rt = http.DefaultTransport
+			}
+			for i := len(c.middlewares) - 1; i >= 0; i-- {
+				rt = c.middlewares[i](rt)
+			}
+			cl.Transport = rt
+		}
+		c.client = &cl
+	}
+	return c
+}
+
+// do sends the request, retrying it according to the retry policy if
+// retryable is true. getBody returns a fresh copy of the request body,
+// and is nil if the request has no body
+func (c *Client) do(ctx context.Context, req *http.Request, getBody func() (io.Reader, error), retryable bool) (*http.Response, error) {
+	p := c.retry
+	if p == nil || !retryable || p.MaxAttempts < 2 {
+		return c.send(ctx, req)
+	}
+
+	shouldRetry := p.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = defaultShouldRetry
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.send(ctx, req)
+		if attempt >= p.MaxAttempts || !shouldRetry(res, err) {
+			return res, err
+		}
+
+		if pdebug.Enabled {
+			pdebug.Printf("attempt %d failed, retrying", attempt)
+		}
+
+		t := time.NewTimer(p.backoff(attempt, res))
+		if res != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, MaxResponseSize))
+			res.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, errors.Wrap(err, ` + "`failed to rewind request body`" + `)
+			}
+			req.Body = readCloser(body)
+		}
+	}
+}
+
+// readCloser returns r as an io.ReadCloser, adding a no-op Close method
+// if it does not have one
+func readCloser(r io.Reader) io.ReadCloser {
+	if rc, ok := r.(io.ReadCloser); ok {
+		return rc
+	}
+	return ioutil.NopCloser(r)
+}
+
+// streamReader reads the elements of a streaming response, sent either
+// as Server-Sent Events or as newline delimited JSON
+type streamReader struct {
+	r   *bufio.Reader
+	sse bool
+}
+
+func newStreamReader(r io.Reader, sse bool) *streamReader {
+	return &streamReader{r: bufio.NewReader(r), sse: sse}
+}
+
+// readLine reads a single line, without the line terminator. Lines
+// longer than MaxResponseSize are rejected
+func (s *streamReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := s.r.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return line, nil
+			}
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > MaxResponseSize {
+			return nil, errors.New(` + "`stream element too large`" + `)
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// next returns the next element in the stream, or io.EOF once the
+// stream has ended. Events without data, and fields other than "data"
+// are ignored
+func (s *streamReader) next() ([]byte, error) {
+	if !s.sse {
+		for {
+			line, err := s.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				return line, nil
+			}
+		}
+	}
+
+	var data []byte
+	var hasData bool
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			// Incomplete events are discarded
+			return nil, err
+		}
+		if len(line) == 0 {
+			if hasData {
+				return data, nil
+			}
+			continue
+		}
+		if line[0] == ':' {
+			continue // comment
+		}
+
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i > -1 {
+			field, value = line[:i], line[i+1:]
+			if len(value) > 0 && value[0] == ' ' {
+				value = value[1:]
+			}
+		}
+		if string(field) != "data" {
+			continue
+		}
+		if hasData {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+		hasData = true
+	}
+}
+
+// File is a file to be uploaded in a multipart/form-data request.
+// If the request is to be retried, Reader must also be an io.Seeker
+type File struct {
+	Name        string // file name reported to the server
+	ContentType string // defaults to application/octet-stream
+	Reader      io.Reader
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", ` + "`\"`" + `, "\\\"")
+
+// multipartBody returns a function that creates a reader streaming the
+// multipart/form-data request body, along with its content type. The
+// files are rewound to their original offsets each time the function
+// is called after the first time, which fails if they are not seekable
+func multipartBody(payload []byte, fields []string, files map[string]File) (func() (io.Reader, error), string) {
+	boundary := multipart.NewWriter(ioutil.Discard).Boundary()
+	offsets := make(map[string]int64)
+	var calls int
+	var prev *io.PipeReader
+	var done chan struct{}
+	getBody := func() (io.Reader, error) {
+		calls++
+
+		// The previous body may still be being written. Stop it, and
+		// wait until it is no longer reading the files before rewinding
+		if prev != nil {
+			prev.Close()
+			<-done
+			prev = nil
+		}
+
+		for _, field := range fields {
+			f, ok := files[field]
+			if !ok {
+				continue
+			}
+			s, seekable := f.Reader.(io.Seeker)
+			if calls == 1 {
+				if seekable {
+					if off, err := s.Seek(0, io.SeekCurrent); err == nil {
+						offsets[field] = off
+					}
+				}
+				continue
+			}
+
+			off, ok := offsets[field]
+			if !ok {
+				return nil, errors.Errorf(` + "`file for '%s' is not seekable`" + `, field)
+			}
+			if _, err := s.Seek(off, io.SeekStart); err != nil {
+				return nil, errors.Wrapf(err, ` + "`failed to rewind file for '%s'`" + `, field)
+			}
+		}
+
+		pr, pw := io.Pipe()
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			w := multipart.NewWriter(pw)
+			w.SetBoundary(boundary)
+			pw.CloseWithError(writeMultipart(w, payload, fields, files))
+		}()
+		prev, done = pr, finished
+		return pr, nil
+	}
+	return getBody, "multipart/form-data; boundary=" + boundary
+}
+
+func writeMultipart(w *multipart.Writer, payload []byte, fields []string, files map[string]File) error {
+	if err := w.WriteField("payload", string(payload)); err != nil {
+		return errors.Wrap(err, ` + "`failed to write payload`" + `)
+	}
+
+	for _, field := range fields {
+		f, ok := files[field]
+		if !ok {
+			continue
+		}
+
+		ct := f.ContentType
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(` + "`form-data; name=\"%s\"; filename=\"%s\"`" + `, quoteEscaper.Replace(field), quoteEscaper.Replace(f.Name)))
+		h.Set("Content-Type", ct)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return errors.Wrapf(err, ` + "`failed to create part for '%s'`" + `, field)
+		}
+		if _, err := io.Copy(part, f.Reader); err != nil {
+			return errors.Wrapf(err, ` + "`failed to write file for '%s'`" + `, field)
+		}
+	}
+	return w.Close()
+}
+
+// setDefaultHeaders sets the headers specified via options, unless
+// they have already been set
+func (c *Client) setDefaultHeaders(req *http.Request) {
+	for k, v := range c.headers {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
 	}
 }
 
@@ -517,8 +2058,54 @@ func (c *Client) SetMutator(m func(*http.Request) error) {
 	c.mutator = m
 }
 
+// codec returns the codec for the media type mt. The codecs for the
+// media types of the links are always registered
+func (c *Client) codec(mt string) Codec {
+	codec, _ := lookupCodec(c.codecs, mt)
+	return codec
+}
+
+// responseCodec returns the codec for the body of res. The media type
+// of the link, mt, is used if the Content-Type is absent, or has no
+// codec registered
+func (c *Client) responseCodec(res *http.Response, mt string) Codec {
+	if ct, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil {
+		if codec, ok := lookupCodec(c.codecs, ct); ok {
+			return codec
+		}
+	}
+	return c.codec(mt)
+}
 `)
 
+	genutil.WriteCodecs(&buf, ctx.RequestMediaType, ctx.ResponseMediaType)
+	if ctx.Validate {
+		genutil.WriteValidationDetails(&buf)
+	}
+
+	buf.WriteString("\n// send sends the request once")
+	buf.WriteString("\nfunc (c *Client) send(ctx context.Context, req *http.Request) (*http.Response, error) {")
+	if genutil.VersionCompare(ctx.GoVersion, "1.7") >= 0 {
+		// The context is already associated with the request
+		buf.WriteString("\nreturn c.client.Do(req)")
+	} else {
+		buf.WriteString("\nreturn ctxhttp.Do(ctx, c.client, req)")
+	}
+	buf.WriteString("\n}\n\n")
+
+	buf.WriteString("\n// API is the interface implemented by Client. Depend on API rather than")
+	buf.WriteString("\n// *Client to be able to substitute the implementation, e.g. with the")
+	buf.WriteString("\n// one in the fake package")
+	buf.WriteString("\ntype API interface {")
+	for _, methodName := range ctx.MethodNames {
+		for _, sig := range ctx.Signatures[methodName] {
+			buf.WriteString("\n" + sig.Name)
+			sig.write(&buf, "")
+		}
+	}
+	buf.WriteString("\n}")
+	buf.WriteString("\n\nvar _ API = (*Client)(nil)\n\n")
+
 	// for each endpoint, create a method that accepts
 	for _, methodName := range ctx.MethodNames {
 		method := ctx.Methods[methodName]
@@ -0,0 +1,263 @@
+package genutil
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Media types of the codecs that generated code knows about
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeForm    = "application/x-www-form-urlencoded"
+	MediaTypeMsgpack = "application/msgpack"
+	MediaTypeCBOR    = "application/cbor"
+)
+
+const (
+	msgpackImport = "github.com/vmihailenco/msgpack/v5"
+	cborImport    = "github.com/fxamacker/cbor/v2"
+)
+
+// CodecMediaType normalizes mt, as specified by the encType or mediaType
+// of a link, into a media type that generated code has a codec for.
+// Parameters are dropped, aliases are replaced, and the empty string
+// means JSON. Media types with the +json suffix are kept as is, as they
+// are handled by the JSON codec
+func CodecMediaType(mt string) (string, error) {
+	if mt == "" {
+		return MediaTypeJSON, nil
+	}
+
+	parsed, _, err := mime.ParseMediaType(mt)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid media type '%s'", mt)
+	}
+
+	switch {
+	case parsed == MediaTypeJSON, strings.HasSuffix(parsed, "+json"):
+		return parsed, nil
+	case parsed == MediaTypeForm:
+		return MediaTypeForm, nil
+	case parsed == MediaTypeMsgpack, parsed == "application/x-msgpack", parsed == "application/vnd.msgpack":
+		return MediaTypeMsgpack, nil
+	case parsed == MediaTypeCBOR:
+		return MediaTypeCBOR, nil
+	}
+	return "", errors.Errorf("unsupported media type '%s'", mt)
+}
+
+// codecsUsed returns whether msgpack and CBOR are among the media types
+func codecsUsed(mediaTypes map[string]string) (msgpack bool, cbor bool) {
+	for _, mt := range mediaTypes {
+		switch mt {
+		case MediaTypeMsgpack:
+			msgpack = true
+		case MediaTypeCBOR:
+			cbor = true
+		}
+	}
+	return
+}
+
+// CodecImports returns the imports required by the code generated by
+// WriteCodecs, other than those of JSON and form encoding
+func CodecImports(mediaTypes ...map[string]string) (stdlibs []string, extlibs []string) {
+	var msgpack, cbor bool
+	for _, m := range mediaTypes {
+		mp, cb := codecsUsed(m)
+		msgpack = msgpack || mp
+		cbor = cbor || cb
+	}
+	if msgpack {
+		extlibs = append(extlibs, msgpackImport)
+	}
+	if cbor {
+		stdlibs = append(stdlibs, "reflect")
+		extlibs = append(extlibs, cborImport)
+	}
+	return stdlibs, extlibs
+}
+
+// WriteCodecs writes the Codec interface, and the codecs for the media
+// types used by the links. JSON and form encoding are always available.
+// The generated code expects bytes, encoding/json, net/url, strings and
+// github.com/lestrrat-go/urlenc to be imported, along with whatever
+// CodecImports returns
+func WriteCodecs(buf *bytes.Buffer, mediaTypes ...map[string]string) {
+	var msgpack, cbor bool
+	for _, m := range mediaTypes {
+		mp, cb := codecsUsed(m)
+		msgpack = msgpack || mp
+		cbor = cbor || cb
+	}
+
+	buf.WriteString(`
+// Codec marshals and unmarshals request and response bodies of a
+// given media type
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type queryMarshaler interface {
+	MarshalQuery() (url.Values, error)
+}
+
+type queryUnmarshaler interface {
+	UnmarshalQuery(url.Values) error
+}
+
+// formCodec encodes values as application/x-www-form-urlencoded. Types
+// generated for form encoded payloads convert themselves, others are
+// handled by urlenc
+type formCodec struct{}
+
+func (formCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(queryMarshaler); ok {
+		q, err := m.MarshalQuery()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(q.Encode()), nil
+	}
+	return urlenc.Marshal(v)
+}
+
+func (formCodec) Unmarshal(data []byte, v interface{}) error {
+	switch x := v.(type) {
+	case queryUnmarshaler:
+		q, err := url.ParseQuery(string(data))
+		if err != nil {
+			return err
+		}
+		return x.UnmarshalQuery(q)
+	case *interface{}:
+		q, err := url.ParseQuery(string(data))
+		if err != nil {
+			return err
+		}
+		m := make(map[string]interface{}, len(q))
+		for k, vals := range q {
+			if len(vals) == 1 {
+				m[k] = vals[0]
+				continue
+			}
+			list := make([]interface{}, len(vals))
+			for i, s := range vals {
+				list[i] = s
+			}
+			m[k] = list
+		}
+		*x = m
+		return nil
+	}
+	return urlenc.Unmarshal(data, v)
+}
+`)
+
+	if msgpack {
+		buf.WriteString(`
+// msgpackCodec encodes values as MessagePack, using the same field
+// names as JSON
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+`)
+	}
+
+	if cbor {
+		buf.WriteString(`
+// cborDecMode decodes maps into map[string]interface{}, like encoding/json
+var cborDecMode = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}(nil))}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+// cborCodec encodes values as CBOR, using the same field names as JSON
+type cborCodec struct{}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return cborDecMode.Unmarshal(data, v)
+}
+`)
+	}
+
+	codecs := map[string]string{
+		MediaTypeJSON: "jsonCodec{}",
+		MediaTypeForm: "formCodec{}",
+	}
+	if msgpack {
+		codecs[MediaTypeMsgpack] = "msgpackCodec{}"
+	}
+	if cbor {
+		codecs[MediaTypeCBOR] = "cborCodec{}"
+	}
+	keys := make([]string, 0, len(codecs))
+	for k := range codecs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf.WriteString("\n// defaultCodecs returns the codecs for the media types used by the API")
+	buf.WriteString("\nfunc defaultCodecs() map[string]Codec {")
+	buf.WriteString("\nreturn map[string]Codec{")
+	for _, k := range keys {
+		fmt.Fprintf(buf, "\n%s: %s,", strconv.Quote(k), codecs[k])
+	}
+	buf.WriteString("\n}")
+	buf.WriteString("\n}")
+
+	buf.WriteString(`
+
+// lookupCodec returns the codec for the media type mt. Media types with
+// the +json suffix are handled by the JSON codec
+func lookupCodec(codecs map[string]Codec, mt string) (Codec, bool) {
+	mt = strings.ToLower(mt)
+	if c, ok := codecs[mt]; ok {
+		return c, true
+	}
+	if strings.HasSuffix(mt, "+json") {
+		c, ok := codecs["` + MediaTypeJSON + `"]
+		return c, ok
+	}
+	return nil, false
+}
+`)
+}
@@ -42,6 +42,59 @@ func TitleToName(s string) string {
 	return buf.String()
 }
 
+var nonalnumrx = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+var initialisms = map[string]struct{}{
+	"api":  {},
+	"http": {},
+	"id":   {},
+	"ip":   {},
+	"json": {},
+	"uri":  {},
+	"url":  {},
+	"uuid": {},
+}
+
+// CamelCase converts names such as "user_id" or "user-id" into
+// exported Go identifiers such as "UserID"
+func CamelCase(s string) string {
+	buf := bytes.Buffer{}
+	for _, p := range nonalnumrx.Split(s, -1) {
+		if p == "" {
+			continue
+		}
+		if _, ok := initialisms[strings.ToLower(p)]; ok {
+			buf.WriteString(strings.ToUpper(p))
+			continue
+		}
+		buf.WriteString(strings.ToUpper(p[:1]))
+		buf.WriteString(p[1:])
+	}
+	return buf.String()
+}
+
+// LowerCamelCase is like CamelCase, but the result is suitable
+// for unexported identifiers such as function arguments
+func LowerCamelCase(s string) string {
+	n := CamelCase(s)
+	if n == "" {
+		return n
+	}
+
+	// Lower case the leading upper case run, so that "ID" becomes
+	// "id", and "IDList" becomes "idList"
+	i := 0
+	for i < len(n) && n[i] >= 'A' && n[i] <= 'Z' {
+		i++
+	}
+	switch {
+	case i == len(n) || i <= 1:
+		return strings.ToLower(n[:i]) + n[i:]
+	default:
+		return strings.ToLower(n[:i-1]) + n[i-1:]
+	}
+}
+
 func MakeValidator(s *schema.Schema, ctx interface{}) (*jsval.JSVal, error) {
 	b := builder.New()
 	v, err := b.BuildWithCtx(s, ctx)
@@ -114,13 +167,16 @@ func SplitVersion(v string) []int {
 		return ret
 	}
 
-	for i, e := range list[:2] {
+	for i, e := range list {
 		x, _ := strconv.Atoi(e)
 		ret[i] = x
 	}
 	return ret
 }
 
+// VersionCompare compares two versions of the form "major.minor.patch",
+// and returns 1 if v1 is newer than v2, -1 if v1 is older than v2, and
+// 0 if they are the same
 func VersionCompare(v1, v2 string) int {
 	e1 := SplitVersion(v1)
 	e2 := SplitVersion(v2)
@@ -131,10 +187,10 @@ func VersionCompare(v1, v2 string) int {
 		}
 
 		if e1[i] > e2[i] {
-			return -1
+			return 1
 		}
 		if e1[i] < e2[i] {
-			return 1
+			return -1
 		}
 	}
 	return 0
@@ -0,0 +1,73 @@
+package genutil
+
+import "testing"
+
+func TestCamelCase(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"", ""},
+		{"__", ""},
+		{"x", "X"},
+		{"name", "Name"},
+		{"fooBar", "FooBar"},
+		{"user_name", "UserName"},
+		{"user-name", "UserName"},
+		{"user name.first", "UserNameFirst"},
+		{"user2_name", "User2Name"},
+		{"id", "ID"},
+		{"user_id", "UserID"},
+		{"Json_data", "JSONData"},
+		{"api_url", "APIURL"},
+		{"_leading__and_trailing_", "LeadingAndTrailing"},
+	} {
+		if got := CamelCase(c.name); got != c.expected {
+			t.Errorf("CamelCase(%q): expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestLowerCamelCase(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"", ""},
+		{"x", "x"},
+		{"Name", "name"},
+		{"user_name", "userName"},
+		{"id", "id"},
+		{"URL", "url"},
+		{"user_id", "userID"},
+		{"id_list", "idList"},
+		{"http_status", "httpStatus"},
+		{"api_url", "apiurl"},
+	} {
+		if got := LowerCamelCase(c.name); got != c.expected {
+			t.Errorf("LowerCamelCase(%q): expected %q, got %q", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	for _, c := range []struct {
+		v1       string
+		v2       string
+		expected int
+	}{
+		{"1.7", "1.7", 0},
+		{"1.7.0", "1.7", 0},
+		{"1.8", "1.7", 1},
+		{"1.7", "1.8", -1},
+		{"1.10", "1.9", 1},
+		{"1.7.1", "1.7", 1},
+		{"1.7", "1.7.1", -1},
+		{"2", "1.9", 1},
+		{"1.9.9", "2.0.0", -1},
+	} {
+		if got := VersionCompare(c.v1, c.v2); got != c.expected {
+			t.Errorf("VersionCompare(%q, %q): expected %d, got %d", c.v1, c.v2, c.expected, got)
+		}
+	}
+}
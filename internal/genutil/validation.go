@@ -0,0 +1,77 @@
+package genutil
+
+import "bytes"
+
+// WriteValidationDetails writes validationDetails, which converts the
+// errors returned by the validators to a list of ErrorDetail. The
+// generated code expects bytes and strings to be imported, and an
+// ErrorDetail type with Pointer and Message fields to be declared
+func WriteValidationDetails(buf *bytes.Buffer) {
+	buf.WriteString(`
+// validationDetails converts the error returned by a validator to a list
+// of ErrorDetail. Validators of request payloads report each invalid
+// property separately, and other validators report a single error
+func validationDetails(err error) []ErrorDetail {
+	cause := err
+	for {
+		c, ok := cause.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		cause = c.Cause()
+	}
+
+	if l, ok := cause.(interface{ Errors() []error }); ok {
+		var details []ErrorDetail
+		for _, e := range l.Errors() {
+			details = append(details, validationDetail(e.Error()))
+		}
+		if len(details) > 0 {
+			return details
+		}
+	}
+	return []ErrorDetail{validationDetail(err.Error())}
+}
+
+// validationDetail converts a single error message from the validator to
+// an ErrorDetail. The validator reports the path to the offending value
+// as a chain of messages such as "object property 'foo' validation
+// failed: ...", which is converted to a JSON pointer
+func validationDetail(msg string) ErrorDetail {
+	if i := strings.Index(msg, " failed: "); i > -1 && strings.HasPrefix(msg, "validator ") {
+		msg = msg[i+9:]
+	}
+
+	var ptr bytes.Buffer
+	for {
+		var prefix string
+		switch {
+		case strings.HasPrefix(msg, "object property for '"):
+			prefix = "object property for '"
+		case strings.HasPrefix(msg, "object property '"):
+			prefix = "object property '"
+		}
+		if prefix == "" {
+			break
+		}
+
+		rest := msg[len(prefix):]
+		if i := strings.Index(rest, "' validation failed: "); i > -1 {
+			ptr.WriteByte('/')
+			ptr.WriteString(jsonPointerEscaper.Replace(rest[:i]))
+			msg = rest[i+21:]
+			continue
+		}
+		if strings.HasSuffix(rest, "' is required") {
+			ptr.WriteByte('/')
+			ptr.WriteString(jsonPointerEscaper.Replace(strings.TrimSuffix(rest, "' is required")))
+			msg = "is required"
+		}
+		break
+	}
+	return ErrorDetail{Pointer: ptr.String(), Message: msg}
+}
+
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+`)
+}
@@ -2,30 +2,88 @@ package parser
 
 import (
 	"fmt"
+	"go/token"
+	"net/url"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/lestrrat-go/hsup/ext"
 	"github.com/lestrrat-go/hsup/internal/genutil"
+	"github.com/lestrrat-go/hsup/internal/typegen"
 	"github.com/lestrrat-go/jshschema"
+	"github.com/lestrrat-go/jsschema"
 	"github.com/lestrrat-go/jsval"
 	"github.com/pkg/errors"
 )
 
+// PathParam describes a single variable in a link's URI template
+type PathParam struct {
+	Name   string // name of the variable in the route, e.g. "id" for "/users/{id}"
+	GoName string // name used for Go variables and arguments
+	Type   string // Go type of the variable
+}
+
+// Route identifies a single endpoint by its path and HTTP method
+type Route struct {
+	Path   string // path with URI template variables expressed as "{name}"
+	Method string // upper cased HTTP method, e.g. "GET"
+}
+
+// CORS describes the CORS policy for a link
+type CORS struct {
+	AllowCredentials bool
+	AllowHeaders     []string // empty means whatever the client asks for
+	AllowMethods     []string // empty means the method of the link
+	AllowOrigins     []string
+	ExposeHeaders    []string
+	MaxAge           int // in seconds. 0 means unspecified
+}
+
+// Pagination styles
+const (
+	PaginationCursor = "cursor" // the response holds the cursor for the next page
+	PaginationLink   = "link"   // the response holds the URL of the next page
+)
+
+// Pagination describes how a link returns its results in pages
+type Pagination struct {
+	Style string
+	Param string // request property to send the cursor in (cursor style)
+	Next  string // response property holding the next cursor or URL. For the link style, empty means the Link header
+	Items string // response property holding the items. Empty means the response itself
+}
+
+// Streaming formats
+const (
+	StreamSSE    = "sse"    // Server-Sent Events (text/event-stream)
+	StreamNDJSON = "ndjson" // newline delimited JSON (application/x-ndjson)
+)
+
 type Result struct {
 	Schema              *hschema.HyperSchema
 	Methods             map[string]string
 	MethodNames         []string
 	MethodWrappers      map[string][]string
 	Middlewares         []string
-	PathToMethods       map[string]string
-	RequestCORS         map[string]string
+	Pagination          map[string]*Pagination
+	PathParams          map[string][]PathParam
+	PathToMethods       map[string]map[string]string // path -> HTTP method -> method name
+	RequestCORS         map[string]*CORS
 	RequestMutators     map[string][]string
+	RequestMediaType    map[string]string // media type of request bodies, other than multipart
 	RequestPayloadType  map[string]string
 	RequestValidators   map[string]*jsval.JSVal
+	ResponseMediaType   map[string]string // media type of responses, other than streams
 	ResponsePayloadType map[string]string
 	ResponseValidators  map[string]*jsval.JSVal
+	Retry               map[string]bool // whether the client may retry the request
+	Routes              map[string]Route
+	Stream              map[string]string // format of the elements of streaming responses
+	SuccessStatus       map[string][]int  // status codes that are considered successful. If absent, any 2xx
+	TransportNs         string
+	Types               *typegen.Registry
 }
 
 func Parse(s *hschema.HyperSchema) (*Result, error) {
@@ -34,13 +92,22 @@ func Parse(s *hschema.HyperSchema) (*Result, error) {
 		MethodNames:         make([]string, len(s.Links)),
 		Methods:             make(map[string]string),
 		MethodWrappers:      make(map[string][]string),
-		PathToMethods:       make(map[string]string),
-		RequestCORS:         make(map[string]string),
+		Pagination:          make(map[string]*Pagination),
+		PathParams:          make(map[string][]PathParam),
+		PathToMethods:       make(map[string]map[string]string),
+		RequestCORS:         make(map[string]*CORS),
 		RequestMutators:     make(map[string][]string),
+		RequestMediaType:    make(map[string]string),
 		RequestPayloadType:  make(map[string]string),
 		RequestValidators:   make(map[string]*jsval.JSVal),
+		ResponseMediaType:   make(map[string]string),
 		ResponseValidators:  make(map[string]*jsval.JSVal),
 		ResponsePayloadType: make(map[string]string),
+		Retry:               make(map[string]bool),
+		Routes:              make(map[string]Route),
+		Stream:              make(map[string]string),
+		SuccessStatus:       make(map[string][]int),
+		Types:               typegen.New(s),
 	}
 
 	if err := parse(&ctx, s); err != nil {
@@ -64,19 +131,138 @@ func parse(ctx *Result, s *hschema.HyperSchema) error {
 
 	// We want to know the namespace of the transport.
 	// Normally we just use "model"
-	transportNs, ok := s.Extras[ext.TransportNsKey]
-	if !ok {
-		transportNs = "model"
+	transportNs := "model"
+	if v, ok := s.Extras[ext.TransportNsKey]; ok {
+		ns, ok := v.(string)
+		if !ok {
+			return errors.Errorf("%s must be a string", ext.TransportNsKey)
+		}
+		transportNs = ns
+	}
+	ctx.TransportNs = transportNs
+
+	// The top level CORS policy applies to all links, unless overridden
+	var defaultCORS *CORS
+	if v, ok := s.Extras[ext.CORSKey]; ok {
+		c, err := parseCORS(nil, v)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse top level CORS policy")
+		}
+		defaultCORS = c
+	}
+
+	// Formats may be mapped to arbitrary Go types, which must be set up
+	// before any of the types are declared
+	if v, ok := s.Extras[ext.FormatsKey]; ok {
+		formats, ok := v.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("%s must be an object", ext.FormatsKey)
+		}
+		for format, typ := range formats {
+			spec, ok := typ.(string)
+			if !ok {
+				return errors.Errorf("%s: type for format '%s' must be a string", ext.FormatsKey, format)
+			}
+			ctx.Types.SetFormat(format, spec)
+		}
 	}
+
 	for i, link := range s.Links {
 		if len(link.Title) == 0 {
 			return errors.New("link " + strconv.Itoa(i) + ": hsup requires a 'title' element to generate resources")
 		}
 
 		methodName := genutil.TitleToName(link.Title)
+		method := strings.ToUpper(link.Method)
+		if method == "" {
+			method = "GET"
+		}
 
+		cors := defaultCORS
 		if v, ok := link.Extras[ext.CORSKey]; ok {
-			ctx.RequestCORS[methodName] = v.(string)
+			c, err := parseCORS(defaultCORS, v)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse CORS policy for link '%s'", link.Title)
+			}
+			cors = c
+		}
+		if cors != nil {
+			ctx.RequestCORS[methodName] = cors
+		}
+
+		// Only idempotent requests are retried, unless specified otherwise
+		switch method {
+		case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+			ctx.Retry[methodName] = true
+		}
+		if v, ok := link.Extras[ext.RetryKey]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return errors.Errorf("%s must be a boolean", ext.RetryKey)
+			}
+			ctx.Retry[methodName] = b
+		}
+
+		if v, ok := link.Extras[ext.SuccessStatusKey]; ok {
+			list, err := statusList(v)
+			if err != nil {
+				return errors.Wrapf(err, "invalid value for %s in link '%s'", ext.SuccessStatusKey, link.Title)
+			}
+			ctx.SuccessStatus[methodName] = list
+		}
+
+		// The targetSchema of streaming links describes each element
+		switch link.MediaType {
+		case "text/event-stream":
+			ctx.Stream[methodName] = StreamSSE
+		case "application/x-ndjson", "application/ndjson":
+			ctx.Stream[methodName] = StreamNDJSON
+		}
+		if v, ok := link.Extras[ext.StreamKey]; ok {
+			switch v {
+			case StreamSSE, StreamNDJSON:
+				ctx.Stream[methodName] = v.(string)
+			default:
+				return errors.Errorf("%s must be either '%s' or '%s'", ext.StreamKey, StreamSSE, StreamNDJSON)
+			}
+		}
+		if _, ok := ctx.Stream[methodName]; ok && (link.TargetSchema == nil || method == "HEAD") {
+			return errors.Errorf("streaming link '%s' must have a targetSchema", link.Title)
+		}
+
+		if v, ok := link.Extras[ext.PaginationKey]; ok {
+			pg, err := parsePagination(v)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse pagination for link '%s'", link.Title)
+			}
+			if _, ok := ctx.Stream[methodName]; ok {
+				return errors.Errorf("streaming link '%s' cannot be paginated", link.Title)
+			}
+			ctx.Pagination[methodName] = pg
+
+			// Browsers must be allowed to see the Link header
+			if c := ctx.RequestCORS[methodName]; c != nil && pg.Style == PaginationLink && pg.Next == "" && !containsFold(c.ExposeHeaders, "Link") {
+				exposed := *c
+				exposed.ExposeHeaders = append(append([]string(nil), c.ExposeHeaders...), "Link")
+				ctx.RequestCORS[methodName] = &exposed
+			}
+		}
+
+		// Bodies are encoded as specified by encType and mediaType,
+		// which default to JSON. GET and HEAD requests have no body
+		if link.Schema != nil && method != "GET" && method != "HEAD" && link.EncType != "multipart/form-data" {
+			mt, err := genutil.CodecMediaType(link.EncType)
+			if err != nil {
+				return errors.Wrapf(err, "invalid encType for link '%s'", link.Title)
+			}
+			ctx.RequestMediaType[methodName] = mt
+		}
+		if _, ok := ctx.Stream[methodName]; !ok && link.TargetSchema != nil {
+			mt, err := genutil.CodecMediaType(link.MediaType)
+			if err != nil {
+				return errors.Wrapf(err, "invalid mediaType for link '%s'", link.Title)
+			}
+			ctx.ResponseMediaType[methodName] = mt
 		}
 
 		if cmr, ok := link.Extras[ext.ClientMutateRequestKey]; ok {
@@ -118,6 +304,28 @@ func parse(ctx *Result, s *hschema.HyperSchema) error {
 			if gt, ok := ls.Extras[ext.TypeKey]; ok {
 				ctx.RequestPayloadType[methodName] = gt.(string)
 			} else {
+				if err := ctx.Types.Declare(methodName+"Request", link.Schema); err != nil {
+					return errors.Wrap(err, "failed to declare request payload type")
+				}
+				// GET and HEAD requests receive their payload via the
+				// query string, which is also how forms are encoded
+				switch {
+				case method == "GET" || method == "HEAD":
+					if err := ctx.Types.UseInQuery(methodName + "Request"); err != nil {
+						return errors.Wrap(err, "failed to use request payload type in query")
+					}
+				case ctx.RequestMediaType[methodName] == genutil.MediaTypeForm:
+					if err := ctx.Types.UseInQuery(methodName + "Request"); err != nil {
+						return errors.Wrap(err, "failed to use request payload type in form")
+					}
+				case link.EncType == "multipart/form-data":
+					if err := ctx.Types.UseInMultipart(methodName + "Request"); err != nil {
+						return errors.Wrap(err, "failed to use request payload type in multipart form")
+					}
+				}
+				if err := checkCodec(ctx, methodName+"Request", ctx.RequestMediaType[methodName]); err != nil {
+					return errors.Wrapf(err, "invalid encType for link '%s'", link.Title)
+				}
 				ctx.RequestPayloadType[methodName] = fmt.Sprintf("%s.%sRequest", transportNs, methodName)
 			}
 			v.Name = fmt.Sprintf("HTTP%sRequest", methodName)
@@ -141,6 +349,12 @@ func parse(ctx *Result, s *hschema.HyperSchema) error {
 			if gt, ok := ls.Extras[ext.TypeKey]; ok {
 				ctx.ResponsePayloadType[methodName] = gt.(string)
 			} else {
+				if err := ctx.Types.Declare(methodName+"Response", link.TargetSchema); err != nil {
+					return errors.Wrap(err, "failed to declare response payload type")
+				}
+				if err := checkCodec(ctx, methodName+"Response", ctx.ResponseMediaType[methodName]); err != nil {
+					return errors.Wrapf(err, "invalid mediaType for link '%s'", link.Title)
+				}
 				ctx.ResponsePayloadType[methodName] = fmt.Sprintf("%s.%sResponse", transportNs, methodName)
 			}
 			v.Name = fmt.Sprintf("HTTP%sResponse", methodName)
@@ -148,13 +362,446 @@ func parse(ctx *Result, s *hschema.HyperSchema) error {
 		}
 
 		ctx.MethodNames[i] = methodName
-		path := link.Path()
-		if strings.IndexRune(path, '{') > -1 {
-			return errors.New("found '{' in the URL. hsup does not support URI templates")
+		path, params, err := parsePath(ctx, link.Path())
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse href for link '%s'", link.Title)
+		}
+		if len(params) > 0 {
+			ctx.PathParams[methodName] = params
 		}
-		ctx.PathToMethods[path] = methodName
 
+		methods, ok := ctx.PathToMethods[path]
+		if !ok {
+			methods = make(map[string]string)
+			ctx.PathToMethods[path] = methods
+		}
+		if n, ok := methods[method]; ok {
+			return errors.Errorf("links '%s' and '%s' both handle %s %s", n, methodName, method, path)
+		}
+		methods[method] = methodName
+		ctx.Routes[methodName] = Route{Path: path, Method: method}
 	}
 	sort.Strings(ctx.MethodNames)
 	return nil
 }
+
+// GeneratedType returns the generated type for the given payload type
+// name (e.g. "model.FooRequest"), if the type was generated by hsup
+func (r *Result) GeneratedType(name string) (*typegen.Type, bool) {
+	prefix := r.TransportNs + "."
+	if !strings.HasPrefix(name, prefix) {
+		return nil, false
+	}
+	return r.Types.Lookup(strings.TrimPrefix(name, prefix))
+}
+
+// parseCORS parses the value of hsup.cors, which may be a string
+// specifying the allowed origin, an object, or false to disable CORS.
+// Fields not specified in the object are inherited from def
+func parseCORS(def *CORS, v interface{}) (*CORS, error) {
+	var c CORS
+	if def != nil {
+		c = *def
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return nil, errors.Errorf("%s must be a string, an object, or false", ext.CORSKey)
+		}
+		return nil, nil
+	case string:
+		c.AllowOrigins = []string{v}
+		return &c, nil
+	case map[string]interface{}:
+		for key, value := range v {
+			var err error
+			switch key {
+			case "origins":
+				c.AllowOrigins, err = stringList(value)
+			case "methods":
+				c.AllowMethods, err = stringList(value)
+				for i, m := range c.AllowMethods {
+					c.AllowMethods[i] = strings.ToUpper(m)
+				}
+			case "headers":
+				c.AllowHeaders, err = stringList(value)
+			case "expose_headers":
+				c.ExposeHeaders, err = stringList(value)
+			case "credentials":
+				b, ok := value.(bool)
+				if !ok {
+					err = errors.New("must be a boolean")
+				}
+				c.AllowCredentials = b
+			case "max_age":
+				n, ok := value.(float64)
+				if !ok || n < 0 || n != float64(int(n)) {
+					err = errors.New("must be a non-negative integer")
+				}
+				c.MaxAge = int(n)
+			default:
+				err = errors.New("unknown key")
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid value for %s.%s", ext.CORSKey, key)
+			}
+		}
+		if len(c.AllowOrigins) == 0 {
+			return nil, errors.Errorf("%s.origins must be specified", ext.CORSKey)
+		}
+		return &c, nil
+	default:
+		return nil, errors.Errorf("%s must be a string, an object, or false", ext.CORSKey)
+	}
+}
+
+// parsePagination parses the value of hsup.pagination, which is either
+// the name of the style, or an object
+func parsePagination(v interface{}) (*Pagination, error) {
+	var pg Pagination
+	switch v := v.(type) {
+	case string:
+		pg.Style = v
+	case map[string]interface{}:
+		for key, value := range v {
+			s, ok := value.(string)
+			if !ok {
+				return nil, errors.Errorf("%s.%s must be a string", ext.PaginationKey, key)
+			}
+			switch key {
+			case "style":
+				pg.Style = s
+			case "param":
+				pg.Param = s
+			case "next":
+				pg.Next = s
+			case "items":
+				pg.Items = s
+			default:
+				return nil, errors.Errorf("unknown key %s.%s", ext.PaginationKey, key)
+			}
+		}
+	default:
+		return nil, errors.Errorf("%s must be a string or an object", ext.PaginationKey)
+	}
+
+	switch pg.Style {
+	case PaginationCursor:
+		if pg.Param == "" || pg.Next == "" {
+			return nil, errors.Errorf("%s.param and %s.next must be specified for the cursor style", ext.PaginationKey, ext.PaginationKey)
+		}
+	case PaginationLink:
+		if pg.Param != "" {
+			return nil, errors.Errorf("%s.param cannot be specified for the link style", ext.PaginationKey)
+		}
+	default:
+		return nil, errors.Errorf("%s.style must be either '%s' or '%s'", ext.PaginationKey, PaginationCursor, PaginationLink)
+	}
+	return &pg, nil
+}
+
+// checkCodec makes sure that the generated type `name` can be converted
+// by the codec for the media type mt. Enums and unions are only
+// converted correctly by the JSON codec
+func checkCodec(ctx *Result, name, mt string) error {
+	switch mt {
+	case genutil.MediaTypeMsgpack, genutil.MediaTypeCBOR:
+	default:
+		return nil
+	}
+	if ctx.Types.NeedsJSON(name) {
+		return errors.Errorf("payloads with enums or unions cannot be encoded as %s, use JSON instead", mt)
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusList converts v, which must be an HTTP status code or a list of
+// HTTP status codes, to a list of ints
+func statusList(v interface{}) ([]int, error) {
+	var l []interface{}
+	switch v := v.(type) {
+	case float64:
+		l = []interface{}{v}
+	case []interface{}:
+		l = v
+	default:
+		return nil, errors.New("must be a status code or a list of status codes")
+	}
+
+	if len(l) == 0 {
+		return nil, errors.New("must not be empty")
+	}
+
+	list := make([]int, len(l))
+	for i, e := range l {
+		n, ok := e.(float64)
+		if !ok || n != float64(int(n)) || n < 100 || n > 599 {
+			return nil, errors.Errorf("invalid status code %v", e)
+		}
+		list[i] = int(n)
+	}
+	return list, nil
+}
+
+// stringList converts v, which must be a string or a list of strings,
+// to a list of strings
+func stringList(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		l := make([]string, len(v))
+		for i, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, errors.New("must be a string or a list of strings")
+			}
+			l[i] = s
+		}
+		return l, nil
+	default:
+		return nil, errors.New("must be a string or a list of strings")
+	}
+}
+
+var uritmplrx = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// These names are used by the generated code, either as local variables
+// or as the names of imported packages, and therefore cannot be used as
+// names for path parameters as-is
+var reservedParamNames = map[string]struct{}{
+	// local variables
+	"args":    {},
+	"body":    {},
+	"c":       {},
+	"ctx":     {},
+	"err":     {},
+	"files":   {},
+	"getBody": {},
+	"in":      {},
+	"method":  {},
+	"payload": {},
+	"r":       {},
+	"req":     {},
+	"res":     {},
+	"ret":     {},
+	"u":       {},
+	"vars":    {},
+	"w":       {},
+
+	// imported packages
+	"bufio":     {},
+	"bytes":     {},
+	"cbor":      {},
+	"client":    {},
+	"context":   {},
+	"encoding":  {},
+	"errors":    {},
+	"filepath":  {},
+	"flags":     {},
+	"fmt":       {},
+	"http":      {},
+	"io":        {},
+	"ioutil":    {},
+	"json":      {},
+	"log":       {},
+	"math":      {},
+	"mime":      {},
+	"model":     {},
+	"msgpack":   {},
+	"multipart": {},
+	"mux":       {},
+	"net":       {},
+	"os":        {},
+	"pdebug":    {},
+	"rand":      {},
+	"reflect":   {},
+	"strconv":   {},
+	"strings":   {},
+	"sync":      {},
+	"textproto": {},
+	"time":      {},
+	"url":       {},
+	"urlenc":    {},
+	"validator": {},
+}
+
+// paramGoName returns the name of the Go variable that holds the path
+// parameter name, renaming it if it would clash with a Go keyword or a
+// name used by the generated code
+func paramGoName(name string) string {
+	goname := genutil.LowerCamelCase(name)
+	if goname == "" {
+		return ""
+	}
+	if _, ok := reservedParamNames[goname]; ok || token.IsKeyword(goname) {
+		goname = goname + "Param"
+	}
+	return goname
+}
+
+// parsePath extracts the URI template variables from path, and returns
+// the path rewritten so that each variable is expressed as "{name}",
+// which is the form that both the server router and the client
+// expect.
+//
+// Variables may either be a plain name (e.g. "{id}"), which is looked
+// up in the root schema's properties, or a parenthesized, URL escaped
+// JSON reference (e.g. "{(%23%2Fdefinitions%2Fuser%2Fproperties%2Fid)}"),
+// which is resolved against the root schema.
+func parsePath(ctx *Result, path string) (string, []PathParam, error) {
+	var params []PathParam
+	var perr error
+	seen := make(map[string]struct{})
+	rewritten := uritmplrx.ReplaceAllStringFunc(path, func(m string) string {
+		if perr != nil {
+			return m
+		}
+
+		expr := m[1 : len(m)-1]
+		var name string
+		var s *schema.Schema
+		if strings.HasPrefix(expr, "(") && strings.HasSuffix(expr, ")") {
+			ref, err := url.QueryUnescape(expr[1 : len(expr)-1])
+			if err != nil {
+				perr = errors.Wrapf(err, "failed to unescape URI template variable %s", expr)
+				return m
+			}
+
+			rs := schema.New()
+			rs.Reference = ref
+			s, err = rs.Resolve(ctx.Schema)
+			if err != nil {
+				perr = errors.Wrapf(err, "failed to resolve URI template variable %s", ref)
+				return m
+			}
+
+			// Use the last element of the reference as the name. If
+			// that collides with another variable in the same path
+			// (e.g. "/users/{user id}/posts/{post id}"), qualify it
+			// with the name of the definition it belongs to
+			elems := strings.Split(ref, "/")
+			name = elems[len(elems)-1]
+			if _, ok := seen[name]; ok && len(elems) > 2 {
+				name = elems[len(elems)-3] + "_" + name
+			}
+		} else {
+			name = expr
+			if ctx.Schema.Schema != nil {
+				s = ctx.Schema.Properties[name]
+			}
+		}
+
+		if _, ok := seen[name]; ok {
+			perr = errors.Errorf("duplicate URI template variable '%s'", name)
+			return m
+		}
+		seen[name] = struct{}{}
+
+		typ, err := pathParamType(ctx, s)
+		if err != nil {
+			perr = errors.Wrapf(err, "failed to deduce type for URI template variable '%s'", name)
+			return m
+		}
+
+		goname := paramGoName(name)
+		if goname == "" {
+			perr = errors.Errorf("invalid URI template variable '%s'", name)
+			return m
+		}
+
+		params = append(params, PathParam{
+			Name:   name,
+			GoName: goname,
+			Type:   typ,
+		})
+		return "{" + name + "}"
+	})
+	if perr != nil {
+		return "", nil, perr
+	}
+
+	return rewritten, params, nil
+}
+
+// basicTypes are the predeclared Go types, which do not implement
+// encoding.TextMarshaler
+var basicTypes = map[string]struct{}{
+	"bool":       {},
+	"byte":       {},
+	"complex64":  {},
+	"complex128": {},
+	"float32":    {},
+	"float64":    {},
+	"int":        {},
+	"int8":       {},
+	"int16":      {},
+	"int32":      {},
+	"int64":      {},
+	"rune":       {},
+	"string":     {},
+	"uint":       {},
+	"uint8":      {},
+	"uint16":     {},
+	"uint32":     {},
+	"uint64":     {},
+	"uintptr":    {},
+}
+
+func pathParamType(ctx *Result, s *schema.Schema) (string, error) {
+	if s == nil {
+		return "string", nil
+	}
+
+	if !s.IsResolved() {
+		rs, err := s.Resolve(ctx.Schema)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to resolve schema")
+		}
+		s = rs
+	}
+
+	if gt, ok := s.Extras[ext.TypeKey]; ok {
+		typ, ok := gt.(string)
+		if !ok {
+			return "", errors.Errorf("%s must be a string", ext.TypeKey)
+		}
+		// Anything but the types that are converted with strconv must
+		// be converted from and to text by the type itself
+		switch typ {
+		case "string", "int64", "float64", "bool":
+		default:
+			if _, ok := basicTypes[typ]; ok {
+				return "", errors.Errorf("%s of URI template variables must be string, int64, float64, bool, or a type implementing encoding.TextMarshaler and encoding.TextUnmarshaler", ext.TypeKey)
+			}
+		}
+		return typ, nil
+	}
+
+	if len(s.Type) != 1 {
+		return "string", nil
+	}
+
+	switch s.Type[0] {
+	case schema.IntegerType:
+		return "int64", nil
+	case schema.NumberType:
+		return "float64", nil
+	case schema.BooleanType:
+		return "bool", nil
+	case schema.StringType:
+		return "string", nil
+	default:
+		return "", errors.New("URI template variables must be a string, integer, number or boolean")
+	}
+}
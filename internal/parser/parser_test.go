@@ -0,0 +1,448 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jshschema"
+)
+
+const pathSchema = `{
+  "$schema": "http://json-schema.org/draft-04/hyper-schema",
+  "properties": {
+    "id": {"type": "integer"},
+    "slug": {"type": "string"},
+    "ratio": {"type": "number"},
+    "active": {"type": "boolean"},
+    "since": {"type": "string", "hsup.type": "time.Time"},
+    "count": {"type": "integer", "hsup.type": "int"},
+    "tags": {"type": "array"}
+  },
+  "definitions": {
+    "user": {"properties": {"id": {"type": "string"}}},
+    "post": {"properties": {"id": {"type": "integer"}}}
+  }
+}`
+
+func TestParsePath(t *testing.T) {
+	s, err := hschema.Read(strings.NewReader(pathSchema))
+	if err != nil {
+		t.Fatalf("failed to read schema: %s", err)
+	}
+	ctx := &Result{Schema: s}
+
+	for _, c := range []struct {
+		path     string
+		expected string
+		params   []PathParam
+		err      bool
+	}{
+		{
+			path:     "/users",
+			expected: "/users",
+		},
+		{
+			path:     "/users/{id}",
+			expected: "/users/{id}",
+			params:   []PathParam{{Name: "id", GoName: "id", Type: "int64"}},
+		},
+		{
+			path:     "/users/{unknown}",
+			expected: "/users/{unknown}",
+			params:   []PathParam{{Name: "unknown", GoName: "unknown", Type: "string"}},
+		},
+		{
+			path:     "/items/{slug}/{ratio}/{active}/{since}",
+			expected: "/items/{slug}/{ratio}/{active}/{since}",
+			params: []PathParam{
+				{Name: "slug", GoName: "slug", Type: "string"},
+				{Name: "ratio", GoName: "ratio", Type: "float64"},
+				{Name: "active", GoName: "active", Type: "bool"},
+				{Name: "since", GoName: "since", Type: "time.Time"},
+			},
+		},
+		{
+			path:     "/users/{(%23%2Fdefinitions%2Fuser%2Fproperties%2Fid)}",
+			expected: "/users/{id}",
+			params:   []PathParam{{Name: "id", GoName: "id", Type: "string"}},
+		},
+		{
+			path:     "/users/{(%23%2Fdefinitions%2Fuser%2Fproperties%2Fid)}/posts/{(%23%2Fdefinitions%2Fpost%2Fproperties%2Fid)}",
+			expected: "/users/{id}/posts/{post_id}",
+			params: []PathParam{
+				{Name: "id", GoName: "id", Type: "string"},
+				{Name: "post_id", GoName: "postID", Type: "int64"},
+			},
+		},
+		{
+			path:     "/things/{type}/{client}/{err}",
+			expected: "/things/{type}/{client}/{err}",
+			params: []PathParam{
+				{Name: "type", GoName: "typeParam", Type: "string"},
+				{Name: "client", GoName: "clientParam", Type: "string"},
+				{Name: "err", GoName: "errParam", Type: "string"},
+			},
+		},
+		{
+			path: "/users/{id}/{id}",
+			err:  true,
+		},
+		{
+			path: "/users/{(%23%2Fdefinitions%2Fnope)}",
+			err:  true,
+		},
+		{
+			path: "/users/{tags}",
+			err:  true,
+		},
+		{
+			path: "/users/{count}",
+			err:  true,
+		},
+		{
+			path: "/users/{---}",
+			err:  true,
+		},
+	} {
+		rewritten, params, err := parsePath(ctx, c.path)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.path, err)
+			continue
+		}
+		if rewritten != c.expected {
+			t.Errorf("%s: expected path %s, got %s", c.path, c.expected, rewritten)
+		}
+		if !reflect.DeepEqual(params, c.params) {
+			t.Errorf("%s: expected params %#v, got %#v", c.path, c.params, params)
+		}
+	}
+}
+
+func TestParseCORS(t *testing.T) {
+	def := &CORS{
+		AllowOrigins: []string{"https://example.com"},
+		MaxAge:       60,
+	}
+
+	for _, c := range []struct {
+		name     string
+		def      *CORS
+		value    interface{}
+		expected *CORS
+		err      bool
+	}{
+		{
+			name:     "disabled",
+			def:      def,
+			value:    false,
+			expected: nil,
+		},
+		{
+			name:     "origin",
+			value:    "*",
+			expected: &CORS{AllowOrigins: []string{"*"}},
+		},
+		{
+			name:     "origin inherits the default",
+			def:      def,
+			value:    "*",
+			expected: &CORS{AllowOrigins: []string{"*"}, MaxAge: 60},
+		},
+		{
+			name: "object",
+			value: map[string]interface{}{
+				"origins":        []interface{}{"https://a.example.com", "https://b.example.com"},
+				"methods":        []interface{}{"get", "Post"},
+				"headers":        "X-Token",
+				"expose_headers": []interface{}{"X-Total"},
+				"credentials":    true,
+				"max_age":        float64(300),
+			},
+			expected: &CORS{
+				AllowCredentials: true,
+				AllowHeaders:     []string{"X-Token"},
+				AllowMethods:     []string{"GET", "POST"},
+				AllowOrigins:     []string{"https://a.example.com", "https://b.example.com"},
+				ExposeHeaders:    []string{"X-Total"},
+				MaxAge:           300,
+			},
+		},
+		{
+			name:     "object inherits the default",
+			def:      def,
+			value:    map[string]interface{}{"credentials": true},
+			expected: &CORS{AllowCredentials: true, AllowOrigins: []string{"https://example.com"}, MaxAge: 60},
+		},
+		{
+			name:  "true",
+			value: true,
+			err:   true,
+		},
+		{
+			name:  "number",
+			value: float64(1),
+			err:   true,
+		},
+		{
+			name:  "missing origins",
+			value: map[string]interface{}{"credentials": true},
+			err:   true,
+		},
+		{
+			name:  "unknown key",
+			value: map[string]interface{}{"origins": "*", "foo": "bar"},
+			err:   true,
+		},
+		{
+			name:  "invalid credentials",
+			value: map[string]interface{}{"origins": "*", "credentials": "yes"},
+			err:   true,
+		},
+		{
+			name:  "negative max_age",
+			value: map[string]interface{}{"origins": "*", "max_age": float64(-1)},
+			err:   true,
+		},
+		{
+			name:  "fractional max_age",
+			value: map[string]interface{}{"origins": "*", "max_age": 1.5},
+			err:   true,
+		},
+		{
+			name:  "invalid origins",
+			value: map[string]interface{}{"origins": []interface{}{float64(1)}},
+			err:   true,
+		},
+	} {
+		cors, err := parseCORS(c.def, c.value)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cors, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.expected, cors)
+		}
+	}
+
+	// The default must not be modified by the links that inherit it
+	if !reflect.DeepEqual(def, &CORS{AllowOrigins: []string{"https://example.com"}, MaxAge: 60}) {
+		t.Errorf("default CORS policy was modified: %#v", def)
+	}
+}
+
+func TestStatusList(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		value    interface{}
+		expected []int
+		err      bool
+	}{
+		{name: "single", value: float64(201), expected: []int{201}},
+		{name: "list", value: []interface{}{float64(200), float64(204)}, expected: []int{200, 204}},
+		{name: "empty list", value: []interface{}{}, err: true},
+		{name: "string", value: "200", err: true},
+		{name: "string in list", value: []interface{}{"200"}, err: true},
+		{name: "fraction", value: 200.5, err: true},
+		{name: "too small", value: float64(99), err: true},
+		{name: "too large", value: float64(600), err: true},
+	} {
+		list, err := statusList(c.value)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(list, c.expected) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, list)
+		}
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		value    interface{}
+		expected *Pagination
+		err      bool
+	}{
+		{
+			name:     "link style",
+			value:    "link",
+			expected: &Pagination{Style: PaginationLink},
+		},
+		{
+			name:     "link style with properties",
+			value:    map[string]interface{}{"style": "link", "next": "next_url", "items": "users"},
+			expected: &Pagination{Style: PaginationLink, Next: "next_url", Items: "users"},
+		},
+		{
+			name:     "cursor style",
+			value:    map[string]interface{}{"style": "cursor", "param": "cursor", "next": "next_cursor", "items": "users"},
+			expected: &Pagination{Style: PaginationCursor, Param: "cursor", Next: "next_cursor", Items: "users"},
+		},
+		{
+			name:  "cursor style without properties",
+			value: "cursor",
+			err:   true,
+		},
+		{
+			name:  "cursor style without next",
+			value: map[string]interface{}{"style": "cursor", "param": "cursor"},
+			err:   true,
+		},
+		{
+			name:  "link style with param",
+			value: map[string]interface{}{"style": "link", "param": "page"},
+			err:   true,
+		},
+		{
+			name:  "unknown style",
+			value: "offset",
+			err:   true,
+		},
+		{
+			name:  "missing style",
+			value: map[string]interface{}{"next": "next_url"},
+			err:   true,
+		},
+		{
+			name:  "unknown key",
+			value: map[string]interface{}{"style": "link", "foo": "bar"},
+			err:   true,
+		},
+		{
+			name:  "non-string value",
+			value: map[string]interface{}{"style": "link", "items": float64(1)},
+			err:   true,
+		},
+		{
+			name:  "number",
+			value: float64(1),
+			err:   true,
+		},
+	} {
+		pg, err := parsePagination(c.value)
+		if c.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(pg, c.expected) {
+			t.Errorf("%s: expected %#v, got %#v", c.name, c.expected, pg)
+		}
+	}
+}
+
+func TestResponseMediaType(t *testing.T) {
+	s, err := hschema.Read(strings.NewReader(`{
+  "$schema": "http://json-schema.org/draft-04/hyper-schema",
+  "definitions": {
+    "item": {"type": "object", "properties": {"id": {"type": "integer"}}}
+  },
+  "links": [
+    {"title": "Get Item", "rel": "self", "href": "/items", "method": "GET", "targetSchema": {"$ref": "#/definitions/item"}},
+    {"title": "Check Item", "rel": "self", "href": "/items", "method": "HEAD", "targetSchema": {"$ref": "#/definitions/item"}},
+    {"title": "Export Item", "rel": "self", "href": "/export", "method": "GET", "mediaType": "application/msgpack", "targetSchema": {"$ref": "#/definitions/item"}},
+    {"title": "Delete Item", "rel": "self", "href": "/items", "method": "DELETE"}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("failed to read schema: %s", err)
+	}
+	ctx, err := Parse(s)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %s", err)
+	}
+
+	// HEAD links negotiate the media type like GET links do, even though
+	// the body is never sent
+	expected := map[string]string{
+		"GetItem":    "application/json",
+		"CheckItem":  "application/json",
+		"ExportItem": "application/msgpack",
+	}
+	if !reflect.DeepEqual(ctx.ResponseMediaType, expected) {
+		t.Errorf("expected response media types %v, got %v", expected, ctx.ResponseMediaType)
+	}
+}
+
+func TestCodecPayloads(t *testing.T) {
+	const definitions = `"definitions": {
+    "cat": {"type": "object", "properties": {"kind": {"enum": ["cat"]}, "lives": {"type": "integer"}}, "required": ["kind"]},
+    "dog": {"type": "object", "properties": {"kind": {"enum": ["dog"]}, "good": {"type": "boolean"}}, "required": ["kind"]},
+    "pet": {"oneOf": [{"$ref": "#/definitions/cat"}, {"$ref": "#/definitions/dog"}], "hsup.discriminator": "kind"},
+    "color": {"type": "string", "enum": ["red", "green"]},
+    "owner": {"type": "object", "properties": {"name": {"type": "string"}, "pets": {"type": "array", "items": {"$ref": "#/definitions/pet"}}}},
+    "plain": {"type": "object", "properties": {"name": {"type": "string"}}}
+  }`
+
+	for _, c := range []struct {
+		name string
+		link string
+		err  bool
+	}{
+		{
+			name: "union request as JSON",
+			link: `{"title": "Add Pet", "rel": "create", "href": "/pets", "method": "POST", "schema": {"$ref": "#/definitions/pet"}}`,
+		},
+		{
+			name: "union request as msgpack",
+			link: `{"title": "Add Pet", "rel": "create", "href": "/pets", "method": "POST", "encType": "application/msgpack", "schema": {"$ref": "#/definitions/pet"}}`,
+			err:  true,
+		},
+		{
+			name: "nested union response as CBOR",
+			link: `{"title": "Get Owner", "rel": "self", "href": "/owner", "method": "GET", "mediaType": "application/cbor", "targetSchema": {"$ref": "#/definitions/owner"}}`,
+			err:  true,
+		},
+		{
+			name: "enum property as msgpack",
+			link: `{"title": "Paint", "rel": "create", "href": "/paint", "method": "POST", "encType": "application/msgpack", "schema": {"type": "object", "properties": {"color": {"$ref": "#/definitions/color"}}}}`,
+			err:  true,
+		},
+		{
+			name: "plain object as msgpack and CBOR",
+			link: `{"title": "Echo", "rel": "create", "href": "/echo", "method": "POST", "encType": "application/msgpack", "mediaType": "application/cbor", "schema": {"$ref": "#/definitions/plain"}, "targetSchema": {"$ref": "#/definitions/plain"}}`,
+		},
+	} {
+		s, err := hschema.Read(strings.NewReader(`{
+  "$schema": "http://json-schema.org/draft-04/hyper-schema",
+  ` + definitions + `,
+  "links": [` + c.link + `]
+}`))
+		if err != nil {
+			t.Fatalf("%s: failed to read schema: %s", c.name, err)
+		}
+
+		_, err = Parse(s)
+		if c.err && err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		}
+	}
+}
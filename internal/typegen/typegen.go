@@ -0,0 +1,1330 @@
+package typegen
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat-go/hsup/ext"
+	"github.com/lestrrat-go/hsup/internal/genutil"
+	"github.com/lestrrat-go/jshschema"
+	"github.com/lestrrat-go/jsschema"
+	"github.com/pkg/errors"
+)
+
+type Kind int
+
+const (
+	KindStruct  Kind = iota // type Foo struct { ... }
+	KindDefined             // type Foo Bar
+	KindEnum                // type Foo string, with a constant for each value
+	KindUnion               // type Foo struct { Value FooVariant }, for oneOf/anyOf
+)
+
+// Variant is one of the types that a union may hold
+type Variant struct {
+	Tag  string // value of the discriminator property, if any
+	Type string
+}
+
+// EnumValue is a single value of an enumeration
+type EnumValue struct {
+	Name  string      // name of the Go constant
+	Value interface{} // string or int64
+}
+
+type Field struct {
+	Default     interface{} // value of "default", if any
+	Description string
+	Formatted   bool // true if the type was mapped from a JSON Schema format
+	JSONName    string
+	Name        string
+	Optional    bool // true if the field may be omitted from the JSON
+	Pointer     bool // true if the field is stored as a pointer
+	Required    bool // true if the property is listed in "required"
+	Type        string
+}
+
+type Type struct {
+	Description   string
+	Discriminator string // name of the property that tells union variants apart
+	Exclusive     bool   // true if exactly one union variant must match (oneOf)
+	Fields        []*Field
+	Kind          Kind
+	Multipart     bool // true if the type is sent as a multipart form
+	Name          string
+	Query         bool // true if the type is sent as a URL query string, or form encoded
+	Underlying    string
+	Unions        []string // names of the unions that this type is a variant of
+	Values        []*EnumValue
+	Variants      []*Variant
+}
+
+// Registry collects the Go types that need to be generated to
+// represent the payloads described in a JSON Hyper Schema
+type Registry struct {
+	formats map[string]string
+	imports map[string]struct{}
+	refs    map[string]string
+	root    *hschema.HyperSchema
+	types   map[string]*Type
+	wrapURL bool // true if the URL wrapper type is used
+}
+
+// url.URL does not implement encoding.TextMarshaler, so values mapped
+// to it are represented using a generated wrapper type
+const (
+	urlSpec    = "net/url.URL"
+	urlWrapper = "URL"
+)
+
+// DefaultFormats is the default mapping from JSON Schema formats to Go
+// types. Only types that can be converted from and to JSON strings, and
+// that implement encoding.TextMarshaler and encoding.TextUnmarshaler
+// may be used, except for url.URL, which is wrapped in a generated URL
+// type. Formats that are not listed are represented as strings
+func DefaultFormats() map[string]string {
+	return map[string]string{
+		"date-time": "time.Time",
+		"ipv4":      "net.IP",
+		"ipv6":      "net.IP",
+		"uri":       urlSpec,
+	}
+}
+
+func New(root *hschema.HyperSchema) *Registry {
+	return &Registry{
+		formats: DefaultFormats(),
+		imports: make(map[string]struct{}),
+		refs:    make(map[string]string),
+		root:    root,
+		types:   make(map[string]*Type),
+	}
+}
+
+// SetFormat changes the Go type used for values with the given JSON
+// Schema format. The type is specified with its full import path, such
+// as "github.com/google/uuid.UUID". Setting it to "string" disables
+// the mapping
+func (r *Registry) SetFormat(format, typ string) {
+	r.formats[format] = typ
+}
+
+// underlying returns the type `name`, or for defined types, the
+// registered type that it is defined as
+func (r *Registry) underlying(name string) (*Type, bool) {
+	t, ok := r.types[name]
+	if !ok {
+		return nil, false
+	}
+	if t.Kind == KindDefined {
+		if ut, ok := r.types[t.Underlying]; ok {
+			return ut, true
+		}
+	}
+	return t, true
+}
+
+// UseInQuery marks the type `name` as being sent as a URL query string,
+// so that methods to convert it from and to url.Values are generated
+func (r *Registry) UseInQuery(name string) error {
+	t, ok := r.underlying(name)
+	if !ok {
+		return errors.Errorf("type '%s' has not been declared", name)
+	}
+	if t.Kind != KindStruct {
+		return errors.Errorf("type '%s' must be an object to be used in a query string", name)
+	}
+	t.Query = true
+	return nil
+}
+
+// UseInMultipart marks the type `name` as being sent as a multipart
+// form, so that a field to hold the parsed form is generated
+func (r *Registry) UseInMultipart(name string) error {
+	t, ok := r.underlying(name)
+	if !ok {
+		return errors.Errorf("type '%s' has not been declared", name)
+	}
+	if t.Kind != KindStruct {
+		return errors.Errorf("type '%s' must be an object to be used in a multipart form", name)
+	}
+	t.Multipart = true
+	r.imports["mime/multipart"] = struct{}{}
+	return nil
+}
+
+// NeedsJSON returns true if the type `name`, or any of the types that it
+// refers to, is an enum or a union. Their values are converted by their
+// MarshalJSON and UnmarshalJSON methods, which codecs other than JSON
+// do not call
+func (r *Registry) NeedsJSON(name string) bool {
+	return r.needsJSON(name, make(map[string]struct{}))
+}
+
+func (r *Registry) needsJSON(name string, seen map[string]struct{}) bool {
+	name = elemType(name)
+	if _, ok := seen[name]; ok {
+		return false
+	}
+	seen[name] = struct{}{}
+
+	t, ok := r.types[name]
+	if !ok {
+		return false
+	}
+	switch t.Kind {
+	case KindEnum, KindUnion:
+		return true
+	case KindDefined:
+		return r.needsJSON(t.Underlying, seen)
+	}
+	for _, f := range t.Fields {
+		if r.needsJSON(f.Type, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// elemType strips slices, pointers and maps from the type name, so that
+// "[]map[string]*User" becomes "User"
+func elemType(name string) string {
+	for {
+		switch {
+		case strings.HasPrefix(name, "[]"):
+			name = name[2:]
+		case strings.HasPrefix(name, "*"):
+			name = name[1:]
+		case strings.HasPrefix(name, "map[string]"):
+			name = name[len("map[string]"):]
+		default:
+			return name
+		}
+	}
+}
+
+// goType registers the import required for a type specified by its
+// full import path (e.g. "github.com/google/uuid.UUID"), and returns
+// the qualified type name (e.g. "uuid.UUID")
+func (r *Registry) goType(spec string) string {
+	if spec == urlSpec {
+		r.wrapURL = true
+		return urlWrapper
+	}
+
+	i := strings.LastIndex(spec, ".")
+	if i < 0 || strings.LastIndex(spec, "/") > i {
+		return spec
+	}
+
+	pkg := spec[:i]
+	r.imports[pkg] = struct{}{}
+	return path.Base(pkg) + spec[i:]
+}
+
+// mappedFormat returns the Go type specification for the schema's
+// format, if any
+func (r *Registry) mappedFormat(s *schema.Schema) (string, bool) {
+	if s == nil || s.Format == "" {
+		return "", false
+	}
+	if types, _ := nonNullTypes(s); len(types) > 1 || (len(types) == 1 && types[0] != schema.StringType) {
+		return "", false
+	}
+	spec, ok := r.formats[string(s.Format)]
+	if !ok || spec == "" || spec == "string" {
+		return "", false
+	}
+	return spec, true
+}
+
+// isFormatted returns true if values for the schema (or the items in
+// the schema, for arrays) are mapped from a JSON Schema format
+func (r *Registry) isFormatted(s *schema.Schema) bool {
+	if _, ok := r.mappedFormat(s); ok {
+		return true
+	}
+	if s.Items == nil || len(s.Items.Schemas) != 1 {
+		return false
+	}
+	is, err := s.Items.Schemas[0].Resolve(r.root)
+	if err != nil {
+		return false
+	}
+	_, ok := r.mappedFormat(is)
+	return ok
+}
+
+func (r *Registry) Len() int {
+	return len(r.types)
+}
+
+func (r *Registry) Lookup(name string) (*Type, bool) {
+	t, ok := r.types[name]
+	return t, ok
+}
+
+// Types returns the list of registered types, sorted by name
+func (r *Registry) Types() []*Type {
+	names := make([]string, 0, len(r.types))
+	for name := range r.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*Type, len(names))
+	for i, name := range names {
+		list[i] = r.types[name]
+	}
+	return list
+}
+
+// Declare registers a type named `name` that represents the values
+// described by the schema `s`. Types referred to from `s` (such as
+// definitions, or nested objects) are registered as well.
+func (r *Registry) Declare(name string, s *schema.Schema) error {
+	if _, ok := r.types[name]; ok {
+		return errors.Errorf("type '%s' has already been declared", name)
+	}
+
+	typ, err := r.typeOf(s, name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to deduce type for '%s'", name)
+	}
+
+	// typeOf() has already declared a struct by this name
+	if typ == name {
+		return nil
+	}
+
+	return r.add(&Type{
+		Description: s.Description,
+		Kind:        KindDefined,
+		Name:        name,
+		Underlying:  typ,
+	})
+}
+
+func (r *Registry) add(t *Type) error {
+	if _, ok := r.types[t.Name]; ok {
+		return errors.Errorf("type '%s' has already been declared", t.Name)
+	}
+	r.types[t.Name] = t
+	return nil
+}
+
+// refName creates a type name from a JSON reference, such that
+// "#/definitions/user" becomes "User", and
+// "#/definitions/user/definitions/profile" becomes "UserProfile"
+func refName(ref string) string {
+	i := strings.IndexRune(ref, '#')
+	if i < 0 {
+		return ""
+	}
+
+	var parts []string
+	for _, p := range strings.Split(ref[i+1:], "/") {
+		switch p {
+		case "", "definitions", "properties":
+			continue
+		}
+		parts = append(parts, p)
+	}
+	return genutil.CamelCase(strings.Join(parts, "_"))
+}
+
+func isObject(s *schema.Schema) bool {
+	if len(s.Properties) > 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		if t == schema.ObjectType {
+			return true
+		}
+	}
+	return false
+}
+
+// nonNullTypes returns the list of types without "null", and
+// whether "null" was included in the list
+func nonNullTypes(s *schema.Schema) (schema.PrimitiveTypes, bool) {
+	var nullable bool
+	list := make(schema.PrimitiveTypes, 0, len(s.Type))
+	for _, t := range s.Type {
+		if t == schema.NullType {
+			nullable = true
+			continue
+		}
+		list = append(list, t)
+	}
+	return list, nullable
+}
+
+// typeOf returns the Go type that represents values described by the
+// schema `s`. `hint` is used to name types that need to be declared
+// along the way, such as nested objects
+func (r *Registry) typeOf(s *schema.Schema, hint string) (string, error) {
+	if s == nil {
+		return "interface{}", nil
+	}
+
+	if ref := s.Reference; ref != "" {
+		if name, ok := r.refs[ref]; ok {
+			return name, nil
+		}
+
+		rs, err := s.Resolve(r.root)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to resolve reference %s", strconv.Quote(ref))
+		}
+
+		// Only objects, enumerations and unions get named after the
+		// reference. Everything else is simple enough to be used inline
+		if !isObject(rs) && enumBase(rs) == "" && len(rs.OneOf) == 0 && len(rs.AnyOf) == 0 {
+			return r.typeOf(rs, hint)
+		}
+
+		name := refName(ref)
+		if name == "" {
+			name = hint
+		}
+		r.refs[ref] = name
+		return r.typeOf(rs, name)
+	}
+
+	if gt, ok := s.Extras[ext.TypeKey]; ok {
+		typ, ok := gt.(string)
+		if !ok {
+			return "", errors.Errorf("%s must be a string", ext.TypeKey)
+		}
+		return typ, nil
+	}
+
+	if base := enumBase(s); base != "" {
+		return r.declareEnum(s, hint, base)
+	}
+
+	if spec, ok := r.mappedFormat(s); ok {
+		return r.goType(spec), nil
+	}
+
+	if len(s.OneOf) > 0 || len(s.AnyOf) > 0 {
+		typ, err := r.declareUnion(s, hint)
+		if err != nil {
+			return "", err
+		}
+		if typ != "" {
+			return typ, nil
+		}
+	}
+
+	types, _ := nonNullTypes(s)
+	if len(types) != 1 {
+		if len(types) == 0 && isObject(s) {
+			return r.declareStruct(s, hint)
+		}
+		return "interface{}", nil
+	}
+
+	switch types[0] {
+	case schema.StringType:
+		return "string", nil
+	case schema.IntegerType:
+		return "int64", nil
+	case schema.NumberType:
+		return "float64", nil
+	case schema.BooleanType:
+		return "bool", nil
+	case schema.ArrayType:
+		if s.Items == nil || len(s.Items.Schemas) == 0 || s.Items.TupleMode {
+			return "[]interface{}", nil
+		}
+		typ, err := r.typeOf(s.Items.Schemas[0], hint+"Item")
+		if err != nil {
+			return "", errors.Wrap(err, "failed to deduce type for array items")
+		}
+		return "[]" + typ, nil
+	case schema.ObjectType:
+		if len(s.Properties) > 0 {
+			return r.declareStruct(s, hint)
+		}
+
+		if ap := s.AdditionalProperties; ap != nil && ap.Schema != nil {
+			typ, err := r.typeOf(ap.Schema, hint+"Value")
+			if err != nil {
+				return "", errors.Wrap(err, "failed to deduce type for additional properties")
+			}
+			return "map[string]" + typ, nil
+		}
+		return "map[string]interface{}", nil
+	}
+	return "interface{}", nil
+}
+
+// enumBase returns the Go type that can hold all of the values in
+// the schema's enum, or the empty string if no named type should be
+// generated for it. Only enumerations consisting entirely of strings
+// or entirely of integers are supported
+func enumBase(s *schema.Schema) string {
+	var base string
+	for _, v := range s.Enum {
+		var vbase string
+		switch v := v.(type) {
+		case nil:
+			continue
+		case string:
+			vbase = "string"
+		case float64:
+			if math.Trunc(v) != v {
+				return ""
+			}
+			vbase = "int64"
+		default:
+			return ""
+		}
+
+		if base != "" && base != vbase {
+			return ""
+		}
+		base = vbase
+	}
+	return base
+}
+
+func (r *Registry) declareEnum(s *schema.Schema, name, base string) (string, error) {
+	t := &Type{
+		Description: s.Description,
+		Kind:        KindEnum,
+		Name:        name,
+		Underlying:  base,
+	}
+
+	seen := make(map[string]struct{})
+	for i, v := range s.Enum {
+		var ev EnumValue
+		switch v := v.(type) {
+		case nil:
+			continue
+		case string:
+			ev.Name = name + genutil.CamelCase(v)
+			ev.Value = v
+		case float64:
+			iv := int64(v)
+			if iv < 0 {
+				ev.Name = name + "Minus" + strconv.FormatInt(-iv, 10)
+			} else {
+				ev.Name = name + strconv.FormatInt(iv, 10)
+			}
+			ev.Value = iv
+		}
+
+		// Values such as "" or "-" do not produce a usable name
+		if _, ok := seen[ev.Name]; ok || ev.Name == name {
+			ev.Name = name + "Value" + strconv.Itoa(i)
+		}
+		seen[ev.Name] = struct{}{}
+		t.Values = append(t.Values, &ev)
+	}
+
+	if err := r.add(t); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// discriminator parses the hsup.discriminator extension, which is
+// either the name of the property, or an object such as
+// { "property": "kind", "mapping": { "created": "#/definitions/created" } }
+func discriminator(s *schema.Schema) (string, map[string]string, error) {
+	v, ok := s.Extras[ext.DiscriminatorKey]
+	if !ok {
+		return "", nil, nil
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil, nil
+	case map[string]interface{}:
+		prop, ok := v["property"].(string)
+		if !ok {
+			return "", nil, errors.Errorf("%s.property must be a string", ext.DiscriminatorKey)
+		}
+
+		var mapping map[string]string
+		if mv, ok := v["mapping"]; ok {
+			m, ok := mv.(map[string]interface{})
+			if !ok {
+				return "", nil, errors.Errorf("%s.mapping must be an object", ext.DiscriminatorKey)
+			}
+			// Store it reversed, as we look up tags by reference
+			mapping = make(map[string]string)
+			for tag, ref := range m {
+				sref, ok := ref.(string)
+				if !ok {
+					return "", nil, errors.Errorf("%s.mapping values must be strings", ext.DiscriminatorKey)
+				}
+				mapping[sref] = tag
+			}
+		}
+		return prop, mapping, nil
+	default:
+		return "", nil, errors.Errorf("%s must be a string or an object", ext.DiscriminatorKey)
+	}
+}
+
+// declareUnion declares a union type for schemas with oneOf or anyOf.
+// Only unions where every variant is an object with properties are
+// supported. For
+// anything else the empty string is returned, and the value is
+// treated as an interface{}
+func (r *Registry) declareUnion(s *schema.Schema, name string) (string, error) {
+	variants := s.OneOf
+	if len(variants) == 0 {
+		variants = s.AnyOf
+	}
+
+	for _, v := range variants {
+		rv, err := v.Resolve(r.root)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to resolve union variant")
+		}
+		if len(rv.Properties) == 0 {
+			return "", nil
+		}
+	}
+
+	prop, mapping, err := discriminator(s)
+	if err != nil {
+		return "", err
+	}
+
+	t := &Type{
+		Description:   s.Description,
+		Discriminator: prop,
+		Exclusive:     len(s.OneOf) > 0,
+		Kind:          KindUnion,
+		Name:          name,
+	}
+	if err := r.add(t); err != nil {
+		return "", err
+	}
+
+	tags := make(map[string]struct{})
+	for i, v := range variants {
+		typ, err := r.typeOf(v, name+"Variant"+strconv.Itoa(i))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to deduce type for variant %d", i)
+		}
+
+		vt, ok := r.types[typ]
+		if !ok || vt.Kind != KindStruct {
+			return "", errors.Errorf("variant %d of '%s' must be an object", i, name)
+		}
+		vt.Unions = append(vt.Unions, name)
+
+		variant := &Variant{Type: typ}
+		if prop != "" {
+			if tag, ok := mapping[v.Reference]; ok && v.Reference != "" {
+				variant.Tag = tag
+			} else {
+				// Without an explicit mapping, the variant must
+				// restrict the discriminator property to a single value
+				rv, _ := v.Resolve(r.root)
+				ps, ok := rv.Properties[prop]
+				if ok && !ps.IsResolved() {
+					ps, err = ps.Resolve(r.root)
+					if err != nil {
+						return "", errors.Wrapf(err, "failed to resolve discriminator for variant %d", i)
+					}
+				}
+				if !ok || len(ps.Enum) != 1 {
+					return "", errors.Errorf("variant %d of '%s' must either be listed in %s.mapping, or have a single valued enum for property '%s'", i, name, ext.DiscriminatorKey, prop)
+				}
+				variant.Tag = fmt.Sprint(ps.Enum[0])
+			}
+
+			if _, ok := tags[variant.Tag]; ok {
+				return "", errors.Errorf("duplicate discriminator value '%s' in '%s'", variant.Tag, name)
+			}
+			tags[variant.Tag] = struct{}{}
+		}
+		t.Variants = append(t.Variants, variant)
+	}
+	return name, nil
+}
+
+func (r *Registry) declareStruct(s *schema.Schema, name string) (string, error) {
+	t := &Type{
+		Description: s.Description,
+		Kind:        KindStruct,
+		Name:        name,
+	}
+
+	// Register before looking at the properties, so that recursive
+	// references can find this type
+	if err := r.add(t); err != nil {
+		return "", err
+	}
+
+	pnames := make([]string, 0, len(s.Properties))
+	for pname := range s.Properties {
+		pnames = append(pnames, pname)
+	}
+	sort.Strings(pnames)
+
+	seen := make(map[string]string)
+	for _, pname := range pnames {
+		fname := genutil.CamelCase(pname)
+		if fname == "" {
+			return "", errors.Errorf("could not create a field name for property '%s' in '%s'", pname, name)
+		}
+		if prev, ok := seen[fname]; ok {
+			return "", errors.Errorf("properties '%s' and '%s' in '%s' map to the same field name", prev, pname, name)
+		}
+		seen[fname] = pname
+
+		ps := s.Properties[pname]
+		typ, err := r.typeOf(ps, name+fname)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to deduce type for property '%s'", pname)
+		}
+
+		rs := ps
+		if !rs.IsResolved() {
+			if rs, err = rs.Resolve(r.root); err != nil {
+				return "", errors.Wrapf(err, "failed to resolve property '%s'", pname)
+			}
+		}
+		_, nullable := nonNullTypes(rs)
+
+		f := &Field{
+			Default:     rs.Default,
+			Description: rs.Description,
+			Formatted:   r.isFormatted(rs),
+			JSONName:    pname,
+			Name:        fname,
+			Required:    s.IsPropRequired(pname),
+			Type:        typ,
+		}
+
+		// Required properties, and properties with a default value
+		// (which gets filled in by the validator) are always present.
+		// Everything else is optional, and is represented as a pointer
+		// so that we can tell if it was present or not
+		switch {
+		case !nullable && (s.IsPropRequired(pname) || rs.Default != nil):
+		case genutil.LooksLikeContainer(typ) || !genutil.LooksLikeStruct(typ):
+			f.Optional = true
+		default:
+			f.Optional = true
+			f.Pointer = true
+		}
+		t.Fields = append(t.Fields, f)
+	}
+	return name, nil
+}
+
+func writeComment(buf *bytes.Buffer, description string) {
+	if description == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(description), "\n") {
+		buf.WriteString("\n// ")
+		buf.WriteString(line)
+	}
+}
+
+// Generate writes the Go source code for all of the registered types
+// to `out`, as package `pkg`
+func (r *Registry) Generate(out io.Writer, pkg string) error {
+	buf := bytes.Buffer{}
+
+	genutil.WriteDoNotEdit(&buf)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+
+	stdlibs := []string{"bytes", "encoding", "encoding/json", "errors", "fmt", "net/url", "reflect", "strconv"}
+	var extlibs []string
+	for pkg := range r.imports {
+		// Crude, but the standard library does not have dots in the
+		// first element of the import path
+		if strings.ContainsRune(strings.SplitN(pkg, "/", 2)[0], '.') {
+			extlibs = append(extlibs, pkg)
+		} else if pkg != "encoding" {
+			stdlibs = append(stdlibs, pkg)
+		}
+	}
+	sort.Strings(stdlibs)
+	sort.Strings(extlibs)
+	genutil.WriteImports(&buf, stdlibs, extlibs)
+	buf.WriteString("var _ = bytes.NewReader\n")
+	buf.WriteString("var _ = errors.New\n")
+	buf.WriteString("var _ = fmt.Sprintf\n")
+	buf.WriteString("var _ = json.Marshal\n")
+	buf.WriteString("var _ = strconv.Quote\n")
+	buf.WriteString("var _ = url.Values{}\n")
+
+	buf.WriteString(`
+// formatValue converts values of types that were mapped from JSON
+// Schema formats back to strings, so that they can be validated
+func formatValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case encoding.TextMarshaler:
+		if b, err := x.MarshalText(); err == nil {
+			return string(b)
+		}
+		return v
+	case fmt.Stringer:
+		return x.String()
+	}
+
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		l := make([]interface{}, rv.Len())
+		for i := range l {
+			l[i] = formatValue(rv.Index(i).Interface())
+		}
+		return l
+	}
+	return v
+}
+`)
+
+	if r.wrapURL {
+		if _, ok := r.types[urlWrapper]; ok {
+			return errors.Errorf("type '%s' is needed for values mapped to %s, but has already been declared", urlWrapper, urlSpec)
+		}
+		buf.WriteString(`
+// URL is a url.URL that can be converted from and to text, which is
+// used for values mapped to url.URL
+type URL struct {
+	url.URL
+}
+
+func (u URL) MarshalText() ([]byte, error) {
+	return []byte(u.URL.String()), nil
+}
+
+func (u *URL) UnmarshalText(data []byte) error {
+	parsed, err := url.Parse(string(data))
+	if err != nil {
+		return err
+	}
+	u.URL = *parsed
+	return nil
+}
+`)
+	}
+
+	for _, t := range r.Types() {
+		switch t.Kind {
+		case KindEnum:
+			generateEnum(&buf, t)
+		case KindUnion:
+			generateUnion(&buf, t)
+		case KindStruct:
+			r.generateStruct(&buf, t)
+		case KindDefined:
+			writeComment(&buf, t.Description)
+			fmt.Fprintf(&buf, "\ntype %s %s\n", t.Name, t.Underlying)
+
+			r.generateForwarders(&buf, t)
+		}
+	}
+
+	return genutil.WriteFmtCode(out, &buf)
+}
+
+// generateForwarders writes the methods of the registered type that
+// the defined type `t` is defined as. Defined types do not inherit the
+// methods of their underlying type, and without them the JSON encoding
+// of enums and unions, the validators, and query strings would all
+// silently fall back to their defaults
+func (r *Registry) generateForwarders(buf *bytes.Buffer, t *Type) {
+	ut, ok := r.underlying(t.Name)
+	if !ok || ut == t {
+		return
+	}
+
+	if ut.Kind == KindEnum {
+		fmt.Fprintf(buf, "\nfunc (v %s) Valid() bool {", t.Name)
+		fmt.Fprintf(buf, "\nreturn %s(v).Valid()", ut.Name)
+		buf.WriteString("\n}\n")
+	}
+
+	if ut.Kind == KindEnum || ut.Kind == KindUnion {
+		fmt.Fprintf(buf, "\nfunc (v %s) MarshalJSON() ([]byte, error) {", t.Name)
+		fmt.Fprintf(buf, "\nreturn %s(v).MarshalJSON()", ut.Name)
+		buf.WriteString("\n}\n")
+		fmt.Fprintf(buf, "\nfunc (v *%s) UnmarshalJSON(data []byte) error {", t.Name)
+		fmt.Fprintf(buf, "\nreturn (*%s)(v).UnmarshalJSON(data)", ut.Name)
+		buf.WriteString("\n}\n")
+	}
+
+	if ut.Kind == KindUnion || (ut.Kind == KindStruct && needsAccessors(ut)) {
+		fmt.Fprintf(buf, "\nfunc (v %s) GetPropNames() ([]string, error) {", t.Name)
+		fmt.Fprintf(buf, "\nreturn %s(v).GetPropNames()", ut.Name)
+		buf.WriteString("\n}\n")
+		fmt.Fprintf(buf, "\nfunc (v %s) GetPropValue(name string) (interface{}, error) {", t.Name)
+		fmt.Fprintf(buf, "\nreturn %s(v).GetPropValue(name)", ut.Name)
+		buf.WriteString("\n}\n")
+	}
+
+	if ut.Kind == KindStruct && ut.Query {
+		fmt.Fprintf(buf, "\nfunc (v %s) MarshalQuery() (url.Values, error) {", t.Name)
+		fmt.Fprintf(buf, "\nreturn %s(v).MarshalQuery()", ut.Name)
+		buf.WriteString("\n}\n")
+		fmt.Fprintf(buf, "\nfunc (v *%s) UnmarshalQuery(q url.Values) error {", t.Name)
+		fmt.Fprintf(buf, "\nreturn (*%s)(v).UnmarshalQuery(q)", ut.Name)
+		buf.WriteString("\n}\n")
+	}
+}
+
+func generateEnum(buf *bytes.Buffer, t *Type) {
+	writeComment(buf, t.Description)
+	fmt.Fprintf(buf, "\ntype %s %s\n", t.Name, t.Underlying)
+
+	buf.WriteString("\nconst (")
+	for _, v := range t.Values {
+		fmt.Fprintf(buf, "\n%s %s = ", v.Name, t.Name)
+		switch v := v.Value.(type) {
+		case string:
+			buf.WriteString(strconv.Quote(v))
+		case int64:
+			buf.WriteString(strconv.FormatInt(v, 10))
+		}
+	}
+	buf.WriteString("\n)\n")
+
+	fmt.Fprintf(buf, "\n// Valid returns true if v is one of the values allowed for %s", t.Name)
+	fmt.Fprintf(buf, "\nfunc (v %s) Valid() bool {", t.Name)
+	buf.WriteString("\nswitch v {")
+	buf.WriteString("\ncase ")
+	for i, v := range t.Values {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(v.Name)
+	}
+	buf.WriteString(":")
+	buf.WriteString("\nreturn true")
+	buf.WriteString("\n}")
+	buf.WriteString("\nreturn false")
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v %s) MarshalJSON() ([]byte, error) {", t.Name)
+	buf.WriteString("\nif !v.Valid() {")
+	fmt.Fprintf(buf, "\nreturn nil, fmt.Errorf(`invalid value for %s: %%v`, %s(v))", t.Name, t.Underlying)
+	buf.WriteString("\n}")
+	fmt.Fprintf(buf, "\nreturn json.Marshal(%s(v))", t.Underlying)
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v *%s) UnmarshalJSON(data []byte) error {", t.Name)
+	fmt.Fprintf(buf, "\nvar x %s", t.Underlying)
+	buf.WriteString("\nif err := json.Unmarshal(data, &x); err != nil {")
+	buf.WriteString("\nreturn err")
+	buf.WriteString("\n}")
+	fmt.Fprintf(buf, "\nif !%s(x).Valid() {", t.Name)
+	fmt.Fprintf(buf, "\nreturn fmt.Errorf(`invalid value for %s: %%v`, x)", t.Name)
+	buf.WriteString("\n}")
+	fmt.Fprintf(buf, "\n*v = %s(x)", t.Name)
+	buf.WriteString("\nreturn nil")
+	buf.WriteString("\n}\n")
+}
+
+func generateUnion(buf *bytes.Buffer, t *Type) {
+	names := make([]string, len(t.Variants))
+	for i, v := range t.Variants {
+		names[i] = v.Type
+	}
+
+	fmt.Fprintf(buf, "\n// %sVariant is implemented by the types that %s can hold", t.Name, t.Name)
+	fmt.Fprintf(buf, "\ntype %sVariant interface {", t.Name)
+	fmt.Fprintf(buf, "\nis%s()", t.Name)
+	buf.WriteString("\n}\n")
+
+	writeComment(buf, t.Description)
+	if t.Exclusive {
+		fmt.Fprintf(buf, "\n// %s holds exactly one of %s", t.Name, strings.Join(names, ", "))
+	} else {
+		fmt.Fprintf(buf, "\n// %s holds any one of %s", t.Name, strings.Join(names, ", "))
+	}
+	fmt.Fprintf(buf, "\ntype %s struct {", t.Name)
+	fmt.Fprintf(buf, "\nValue %sVariant", t.Name)
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v %s) MarshalJSON() ([]byte, error) {", t.Name)
+	buf.WriteString("\nif v.Value == nil {")
+	buf.WriteString("\nreturn []byte(`null`), nil")
+	buf.WriteString("\n}")
+	buf.WriteString("\nreturn json.Marshal(v.Value)")
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v *%s) UnmarshalJSON(data []byte) error {", t.Name)
+	if t.Discriminator != "" {
+		buf.WriteString("\nvar probe struct {")
+		fmt.Fprintf(buf, "\nTag interface{} `json:%s`", strconv.Quote(t.Discriminator))
+		buf.WriteString("\n}")
+		buf.WriteString("\nif err := json.Unmarshal(data, &probe); err != nil {")
+		buf.WriteString("\nreturn err")
+		buf.WriteString("\n}")
+		buf.WriteString("\nswitch fmt.Sprint(probe.Tag) {")
+		for _, variant := range t.Variants {
+			fmt.Fprintf(buf, "\ncase %s:", strconv.Quote(variant.Tag))
+			fmt.Fprintf(buf, "\nvar x %s", variant.Type)
+			buf.WriteString("\nif err := json.Unmarshal(data, &x); err != nil {")
+			buf.WriteString("\nreturn err")
+			buf.WriteString("\n}")
+			buf.WriteString("\nv.Value = x")
+			buf.WriteString("\nreturn nil")
+		}
+		buf.WriteString("\n}")
+		fmt.Fprintf(buf, "\nreturn fmt.Errorf(`unknown value for %s.%s: %%v`, probe.Tag)", t.Name, t.Discriminator)
+		buf.WriteString("\n}\n")
+	} else {
+		// Without a discriminator, the only thing we can do is to try
+		// each variant, and see which ones accept the data
+		buf.WriteString("\nvar found []" + t.Name + "Variant")
+		for _, variant := range t.Variants {
+			buf.WriteString("\n{")
+			fmt.Fprintf(buf, "\nvar x %s", variant.Type)
+			buf.WriteString("\ndec := json.NewDecoder(bytes.NewReader(data))")
+			buf.WriteString("\ndec.DisallowUnknownFields()")
+			buf.WriteString("\nif err := dec.Decode(&x); err == nil {")
+			buf.WriteString("\nfound = append(found, x)")
+			buf.WriteString("\n}")
+			buf.WriteString("\n}")
+		}
+		buf.WriteString("\nswitch len(found) {")
+		buf.WriteString("\ncase 0:")
+		fmt.Fprintf(buf, "\nreturn errors.New(`data does not match any of the variants of %s`)", t.Name)
+		if t.Exclusive {
+			buf.WriteString("\ncase 1:")
+			buf.WriteString("\ndefault:")
+			fmt.Fprintf(buf, "\nreturn errors.New(`data matches more than one of the variants of %s`)", t.Name)
+		}
+		buf.WriteString("\n}")
+		buf.WriteString("\nv.Value = found[0]")
+		buf.WriteString("\nreturn nil")
+		buf.WriteString("\n}\n")
+	}
+
+	// Let the validators see the properties of the value being held
+	fmt.Fprintf(buf, "\nfunc (v %s) GetPropNames() ([]string, error) {", t.Name)
+	buf.WriteString("\nif pv, ok := v.Value.(interface{ GetPropNames() ([]string, error) }); ok {")
+	buf.WriteString("\nreturn pv.GetPropNames()")
+	buf.WriteString("\n}")
+	fmt.Fprintf(buf, "\nreturn nil, errors.New(`%s does not hold a value`)", t.Name)
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v %s) GetPropValue(name string) (interface{}, error) {", t.Name)
+	buf.WriteString("\nif pv, ok := v.Value.(interface{ GetPropValue(string) (interface{}, error) }); ok {")
+	buf.WriteString("\nreturn pv.GetPropValue(name)")
+	buf.WriteString("\n}")
+	fmt.Fprintf(buf, "\nreturn nil, errors.New(`%s does not hold a value`)", t.Name)
+	buf.WriteString("\n}\n")
+}
+
+// needsAccessors returns true if the validators need help looking up
+// the properties of the struct
+func needsAccessors(t *Type) bool {
+	// Union variants are always given accessors, so that the union
+	// can forward to them
+	if len(t.Unions) > 0 {
+		return true
+	}
+
+	for _, f := range t.Fields {
+		if f.Optional || f.Formatted {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Registry) generateStruct(buf *bytes.Buffer, t *Type) {
+	writeComment(buf, t.Description)
+	fmt.Fprintf(buf, "\ntype %s struct {", t.Name)
+	for _, f := range t.Fields {
+		writeComment(buf, f.Description)
+		buf.WriteString("\n")
+		buf.WriteString(f.Name)
+		buf.WriteString(" ")
+		if f.Pointer {
+			buf.WriteString("*")
+		}
+		buf.WriteString(f.Type)
+		buf.WriteString(" `json:\"")
+		buf.WriteString(f.JSONName)
+		if f.Optional {
+			buf.WriteString(",omitempty")
+		}
+		buf.WriteString("\"`")
+	}
+	if t.Multipart {
+		buf.WriteString("\n\n// MultipartForm holds the parsed multipart form, including the")
+		buf.WriteString("\n// uploaded files. It is set by the server, and is never encoded")
+		buf.WriteString("\nMultipartForm *multipart.Form `json:\"-\"`")
+	}
+	buf.WriteString("\n}\n")
+
+	for _, u := range t.Unions {
+		fmt.Fprintf(buf, "\nfunc (%s) is%s() {}\n", t.Name, u)
+	}
+
+	if t.Query {
+		r.generateQuery(buf, t)
+	}
+
+	if !needsAccessors(t) {
+		return
+	}
+
+	// The validators would otherwise consider nil values for optional
+	// fields as present. Tell them which properties are really there
+	fmt.Fprintf(buf, "\nfunc (v %s) GetPropNames() ([]string, error) {", t.Name)
+	fmt.Fprintf(buf, "\nnames := make([]string, 0, %d)", len(t.Fields))
+	for _, f := range t.Fields {
+		if f.Optional {
+			fmt.Fprintf(buf, "\nif v.%s != nil {", f.Name)
+		}
+		fmt.Fprintf(buf, "\nnames = append(names, %s)", strconv.Quote(f.JSONName))
+		if f.Optional {
+			buf.WriteString("\n}")
+		}
+	}
+	buf.WriteString("\nreturn names, nil")
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v %s) GetPropValue(name string) (interface{}, error) {", t.Name)
+	buf.WriteString("\nswitch name {")
+	for _, f := range t.Fields {
+		fmt.Fprintf(buf, "\ncase %s:", strconv.Quote(f.JSONName))
+		if f.Optional {
+			fmt.Fprintf(buf, "\nif v.%s == nil {", f.Name)
+			buf.WriteString("\nreturn nil, nil")
+			buf.WriteString("\n}")
+		}
+		buf.WriteString("\nreturn ")
+		if f.Formatted {
+			buf.WriteString("formatValue(")
+		}
+		if f.Pointer {
+			buf.WriteString("*")
+		}
+		fmt.Fprintf(buf, "v.%s", f.Name)
+		if f.Formatted {
+			buf.WriteString(")")
+		}
+		buf.WriteString(", nil")
+	}
+	buf.WriteString("\n}")
+	// Let the validator look up anything else by itself
+	buf.WriteString("\nreturn nil, errors.New(`unknown property '` + name + `'`)")
+	buf.WriteString("\n}\n")
+}
+
+// queryBase returns the type used to convert the field from and to
+// strings in a query string: one of "string", "int64", "float64",
+// "bool", or "text" for types that implement encoding.TextMarshaler
+// and encoding.TextUnmarshaler. The empty string is returned for
+// fields that cannot be expressed in a query string
+func (r *Registry) queryBase(f *Field) string {
+	if f.Formatted {
+		return "text"
+	}
+
+	elem := strings.TrimPrefix(f.Type, "[]")
+	switch elem {
+	case "string", "int64", "float64", "bool":
+		return elem
+	}
+
+	if t, ok := r.types[elem]; ok && t.Kind == KindEnum {
+		return t.Underlying
+	}
+	return ""
+}
+
+// queryDefault returns the Go literal for the default value of a field
+// in a query string. Only scalar fields that are not pointers are
+// handled, as the others are left for the validators
+func queryDefault(f *Field, base string) (string, bool) {
+	if f.Default == nil || f.Pointer || strings.HasPrefix(f.Type, "[]") {
+		return "", false
+	}
+
+	var lit string
+	switch x := f.Default.(type) {
+	case string:
+		if base != "string" {
+			return "", false
+		}
+		lit = strconv.Quote(x)
+	case float64:
+		switch base {
+		case "int64":
+			if x != math.Trunc(x) {
+				return "", false
+			}
+			lit = strconv.FormatInt(int64(x), 10)
+		case "float64":
+			lit = strconv.FormatFloat(x, 'g', -1, 64)
+		default:
+			return "", false
+		}
+	case bool:
+		if base != "bool" {
+			return "", false
+		}
+		lit = strconv.FormatBool(x)
+	default:
+		return "", false
+	}
+
+	if f.Type != base {
+		lit = f.Type + "(" + lit + ")"
+	}
+	return lit, true
+}
+
+func writeQueryDecode(buf *bytes.Buffer, f *Field, base, elem string) {
+	errout := fmt.Sprintf("\nif err != nil {\nreturn fmt.Errorf(`invalid value for query parameter %s: %%s`, err)\n}", f.JSONName)
+	// Basic types can be assigned as-is, other types need conversion
+	assign := "x"
+	if elem != base {
+		assign = "n"
+	}
+	switch base {
+	case "string":
+		if elem == base {
+			buf.WriteString("\nx := s")
+		} else {
+			fmt.Fprintf(buf, "\nx := %s(s)", elem)
+		}
+	case "int64":
+		fmt.Fprintf(buf, "\n%s, err := strconv.ParseInt(s, 10, 64)", assign)
+		buf.WriteString(errout)
+	case "float64":
+		fmt.Fprintf(buf, "\n%s, err := strconv.ParseFloat(s, 64)", assign)
+		buf.WriteString(errout)
+	case "bool":
+		fmt.Fprintf(buf, "\n%s, err := strconv.ParseBool(s)", assign)
+		buf.WriteString(errout)
+	case "text":
+		fmt.Fprintf(buf, "\nvar x %s", elem)
+		buf.WriteString("\nif err := x.UnmarshalText([]byte(s)); err != nil {")
+		fmt.Fprintf(buf, "\nreturn fmt.Errorf(`invalid value for query parameter %s: %%s`, err)", f.JSONName)
+		buf.WriteString("\n}")
+	}
+	if base != "string" && base != "text" && elem != base {
+		fmt.Fprintf(buf, "\nx := %s(n)", elem)
+	}
+
+	switch elem {
+	case "string", "int64", "float64", "bool":
+	default:
+		if base != "text" {
+			buf.WriteString("\nif !x.Valid() {")
+			fmt.Fprintf(buf, "\nreturn fmt.Errorf(`invalid value for query parameter %s: %%v`, s)", f.JSONName)
+			buf.WriteString("\n}")
+		}
+	}
+}
+
+func writeQueryEncode(buf *bytes.Buffer, f *Field, base, expr string) {
+	switch base {
+	case "string":
+		fmt.Fprintf(buf, "\nq.Add(%s, string(%s))", strconv.Quote(f.JSONName), expr)
+	case "int64":
+		fmt.Fprintf(buf, "\nq.Add(%s, strconv.FormatInt(int64(%s), 10))", strconv.Quote(f.JSONName), expr)
+	case "float64":
+		fmt.Fprintf(buf, "\nq.Add(%s, strconv.FormatFloat(float64(%s), 'f', -1, 64))", strconv.Quote(f.JSONName), expr)
+	case "bool":
+		fmt.Fprintf(buf, "\nq.Add(%s, strconv.FormatBool(bool(%s)))", strconv.Quote(f.JSONName), expr)
+	case "text":
+		if strings.HasPrefix(expr, "*") {
+			expr = "(" + expr + ")"
+		}
+		fmt.Fprintf(buf, "\nif b, err := %s.MarshalText(); err != nil {", expr)
+		fmt.Fprintf(buf, "\nreturn nil, fmt.Errorf(`invalid value for query parameter %s: %%s`, err)", f.JSONName)
+		buf.WriteString("\n} else {")
+		fmt.Fprintf(buf, "\nq.Add(%s, string(b))", strconv.Quote(f.JSONName))
+		buf.WriteString("\n}")
+	}
+}
+
+// generateQuery generates methods to convert the struct from and to
+// url.Values. Fields that cannot be expressed in a query string, such
+// as nested objects, are ignored
+func (r *Registry) generateQuery(buf *bytes.Buffer, t *Type) {
+	fmt.Fprintf(buf, "\nfunc (v %s) MarshalQuery() (url.Values, error) {", t.Name)
+	buf.WriteString("\nq := url.Values{}")
+	for _, f := range t.Fields {
+		base := r.queryBase(f)
+		if base == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(f.Type, "[]"):
+			fmt.Fprintf(buf, "\nfor _, x := range v.%s {", f.Name)
+			writeQueryEncode(buf, f, base, "x")
+			buf.WriteString("\n}")
+		case f.Pointer:
+			fmt.Fprintf(buf, "\nif v.%s != nil {", f.Name)
+			writeQueryEncode(buf, f, base, "*v."+f.Name)
+			buf.WriteString("\n}")
+		default:
+			writeQueryEncode(buf, f, base, "v."+f.Name)
+		}
+	}
+	buf.WriteString("\nreturn q, nil")
+	buf.WriteString("\n}\n")
+
+	fmt.Fprintf(buf, "\nfunc (v *%s) UnmarshalQuery(q url.Values) error {", t.Name)
+	for _, f := range t.Fields {
+		base := r.queryBase(f)
+		if base == "" {
+			continue
+		}
+
+		elem := strings.TrimPrefix(f.Type, "[]")
+		fmt.Fprintf(buf, "\nif vals := q[%s]; len(vals) > 0 {", strconv.Quote(f.JSONName))
+		if strings.HasPrefix(f.Type, "[]") {
+			fmt.Fprintf(buf, "\nlist := make(%s, 0, len(vals))", f.Type)
+			buf.WriteString("\nfor _, s := range vals {")
+			writeQueryDecode(buf, f, base, elem)
+			buf.WriteString("\nlist = append(list, x)")
+			buf.WriteString("\n}")
+			fmt.Fprintf(buf, "\nv.%s = list", f.Name)
+		} else {
+			buf.WriteString("\ns := vals[0]")
+			writeQueryDecode(buf, f, base, elem)
+			if f.Pointer {
+				fmt.Fprintf(buf, "\nv.%s = &x", f.Name)
+			} else {
+				fmt.Fprintf(buf, "\nv.%s = x", f.Name)
+			}
+		}
+		buf.WriteString("\n}")
+		// Required fields and fields with a default are not pointers, so
+		// the validators can neither tell that they are missing, nor
+		// fill in the default
+		if f.Required {
+			buf.WriteString(" else {")
+			fmt.Fprintf(buf, "\nreturn errors.New(`missing required query parameter %s`)", f.JSONName)
+			buf.WriteString("\n}")
+		} else if lit, ok := queryDefault(f, base); ok {
+			buf.WriteString(" else {")
+			fmt.Fprintf(buf, "\nv.%s = %s", f.Name, lit)
+			buf.WriteString("\n}")
+		}
+	}
+	buf.WriteString("\nreturn nil")
+	buf.WriteString("\n}\n")
+}
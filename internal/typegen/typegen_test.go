@@ -0,0 +1,120 @@
+package typegen
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/hsup/internal/genutil"
+	"github.com/lestrrat-go/jshschema"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// generateFixture declares the payload types of each link in the schema
+// in the same way as the parser does, and generates them
+func generateFixture(t *testing.T, file string) []byte {
+	s, err := hschema.ReadFile(file)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", file, err)
+	}
+
+	r := New(s)
+	for _, link := range s.Links {
+		name := genutil.TitleToName(link.Title)
+		if link.Schema != nil {
+			if err := r.Declare(name+"Request", link.Schema); err != nil {
+				t.Fatalf("%s: failed to declare request type of '%s': %s", file, link.Title, err)
+			}
+			switch {
+			case strings.EqualFold(link.Method, "GET"), link.EncType == genutil.MediaTypeForm:
+				err = r.UseInQuery(name + "Request")
+			case link.EncType == "multipart/form-data":
+				err = r.UseInMultipart(name + "Request")
+			}
+			if err != nil {
+				t.Fatalf("%s: failed to use request type of '%s': %s", file, link.Title, err)
+			}
+		}
+		if link.TargetSchema != nil {
+			if err := r.Declare(name+"Response", link.TargetSchema); err != nil {
+				t.Fatalf("%s: failed to declare response type of '%s': %s", file, link.Title, err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := r.Generate(&buf, "model"); err != nil {
+		t.Fatalf("%s: failed to generate types: %s", file, err)
+	}
+	return buf.Bytes()
+}
+
+// TestGolden compares the types generated for each schema in testdata
+// with the corresponding .golden file, and makes sure that they compile.
+// Run with -update to regenerate the golden files
+func TestGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatalf("failed to list fixtures: %s", err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures found")
+	}
+
+	dir, err := ioutil.TempDir("", "hsup-typegen")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/golden\n"), 0644); err != nil {
+		t.Fatalf("failed to write go.mod: %s", err)
+	}
+
+	for _, fixture := range fixtures {
+		src := generateFixture(t, fixture)
+
+		golden := strings.TrimSuffix(fixture, ".json") + ".golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, src, 0644); err != nil {
+				t.Fatalf("failed to write %s: %s", golden, err)
+			}
+		} else {
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %s: %s", golden, err)
+			}
+			if !bytes.Equal(src, expected) {
+				t.Errorf("types generated for %s do not match %s (run go test with -update to regenerate)", fixture, golden)
+			}
+		}
+
+		// Each fixture goes in its own package, as type names may clash
+		pkgdir := filepath.Join(dir, strings.TrimSuffix(filepath.Base(fixture), ".json"))
+		if err := os.Mkdir(pkgdir, 0755); err != nil {
+			t.Fatalf("failed to create %s: %s", pkgdir, err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkgdir, "types_hsup.go"), src, 0644); err != nil {
+			t.Fatalf("failed to write types for %s: %s", fixture, err)
+		}
+	}
+
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command is not available")
+	}
+
+	// The generated types only depend on the standard library
+	cmd := exec.Command(gocmd, "vet", "./...")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated types do not compile: %s\n%s", err, out)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
@@ -29,6 +30,7 @@ type Builder struct {
 	GoVersion    string
 	Overwrite    bool
 	PkgPath      string
+	Service      bool
 	ValidatorPkg string
 }
 
@@ -46,11 +48,13 @@ type genctx struct {
 	Overwrite    bool
 	PkgPath      string
 	ServerHints  serverHints
+	Service      bool
 	ValidatorPkg string
 }
 
 type options struct {
 	CLISchema string `long:"clischema"`
+	Service   bool   `long:"service"`
 }
 
 func Process(opts hsup.Options) error {
@@ -66,6 +70,7 @@ func Process(opts hsup.Options) error {
 	b.PkgPath = opts.PkgPath
 	b.Overwrite = opts.Overwrite
 	b.CLISchema = localopts.CLISchema
+	b.Service = localopts.Service
 	if err := b.ProcessFile(opts.Schema); err != nil {
 		return err
 	}
@@ -107,6 +112,7 @@ func (b *Builder) Process(s *hschema.HyperSchema) error {
 		GoVersion:    b.GoVersion,
 		Overwrite:    b.Overwrite,
 		PkgPath:      b.PkgPath,
+		Service:      b.Service,
 		ValidatorPkg: b.ValidatorPkg,
 	}
 
@@ -213,32 +219,66 @@ func parse(ctx *genctx, s *hschema.HyperSchema) error {
 func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 	buf := bytes.Buffer{}
 
-	fmt.Fprintf(&buf, `func http%s(ctx context.Context, w http.ResponseWriter, r *http.Request) {`, name)
+	if ctx.Service {
+		// Handlers need access to the Service, so they are attached
+		// to the Server
+		buf.WriteString("func (s *Server) ")
+	} else {
+		buf.WriteString("func ")
+	}
+	fmt.Fprintf(&buf, `http%s(ctx context.Context, w http.ResponseWriter, r *http.Request) {`, name)
 	buf.WriteString("\nif pdebug.Enabled {")
 	fmt.Fprintf(&buf, "\ng := pdebug.Marker(%s)", strconv.Quote("http"+name))
 	buf.WriteString("\ndefer g.End()")
 	buf.WriteString("\n}")
 
-	method := strings.ToLower(l.Method)
-	if method == "" {
-		method = "get"
-	}
-	buf.WriteString("\nmethod := strings.ToLower(r.Method)")
-	fmt.Fprintf(&buf, "\nif method != `%s` {", method)
-	fmt.Fprintf(&buf, "\n"+`w.Header().Set("Allow", %s)`, strconv.Quote(method))
-	buf.WriteString("\nmsgbuf := getBytesBuffer()")
-	buf.WriteString("\ndefer releaseBytesBuffer(msgbuf)")
-	buf.WriteString("\nmsgbuf.WriteString(`Method was `)")
-	buf.WriteString("\nmsgbuf.WriteString(r.Method)")
-	buf.WriteString("\nmsgbuf.WriteString(`, expected '")
-	buf.WriteString(method)
-	buf.WriteString("'`)")
-	buf.WriteString("\nhttpError(w, msgbuf.String(), http.StatusNotFound, nil)")
-	buf.WriteString("\nreturn")
-	buf.WriteString("\n}\n")
+	// Streaming responses cannot be buffered. Each element is validated
+	// as it is sent instead
+	_, stream := ctx.Stream[name]
+	if v := ctx.ResponseValidators[name]; v != nil && !stream {
+		buf.WriteString("\n\nif ResponseValidation != ResponseValidationOff {")
+		buf.WriteString("\nvw := &validatingResponseWriter{dst: w}")
+		fmt.Fprintf(&buf, "\ndefer vw.finish(%s, %s.%s)", strconv.Quote(name), ctx.ValidatorPkg, v.Name)
+		buf.WriteString("\nw = vw")
+		buf.WriteString("\n}")
+	}
+
+	// Requests with the wrong method never reach this handler,
+	// as they are dispatched by method in SetupRoutes()
+	method := strings.ToLower(ctx.Routes[name].Method)
 
-	if v, ok := ctx.RequestCORS[name]; ok {
-		fmt.Fprintf(&buf, "\nw.Header().Set(`Access-Control-Allow-Origin`, %s)", strconv.Quote(v))
+	if _, ok := ctx.RequestCORS[name]; ok {
+		fmt.Fprintf(&buf, "\ncors%s.apply(w, r)", name)
+	}
+
+	if params := ctx.PathParams[name]; len(params) > 0 {
+		buf.WriteString("\n\nvars := mux.Vars(r)")
+		for _, p := range params {
+			switch p.Type {
+			case "string":
+				fmt.Fprintf(&buf, "\n%s := vars[%s]", p.GoName, strconv.Quote(p.Name))
+				continue
+			case "int64":
+				fmt.Fprintf(&buf, "\n%s, err := strconv.ParseInt(vars[%s], 10, 64)", p.GoName, strconv.Quote(p.Name))
+			case "float64":
+				fmt.Fprintf(&buf, "\n%s, err := strconv.ParseFloat(vars[%s], 64)", p.GoName, strconv.Quote(p.Name))
+			case "bool":
+				fmt.Fprintf(&buf, "\n%s, err := strconv.ParseBool(vars[%s])", p.GoName, strconv.Quote(p.Name))
+			default:
+				// Other types are converted from text, in the same way as
+				// the client converts them to text
+				fmt.Fprintf(&buf, "\nvar %s %s", p.GoName, p.Type)
+				fmt.Fprintf(&buf, "\nif err := %s.UnmarshalText([]byte(vars[%s])); err != nil {", p.GoName, strconv.Quote(p.Name))
+				fmt.Fprintf(&buf, "\nhttpError(w, `Invalid path parameter %s`, http.StatusBadRequest, err)", p.Name)
+				buf.WriteString("\nreturn")
+				buf.WriteString("\n}")
+				continue
+			}
+			buf.WriteString("\nif err != nil {")
+			fmt.Fprintf(&buf, "\nhttpError(w, `Invalid path parameter %s`, http.StatusBadRequest, err)", p.Name)
+			buf.WriteString("\nreturn")
+			buf.WriteString("\n}")
+		}
 	}
 
 	payloadType := ctx.RequestPayloadType[name]
@@ -246,11 +286,12 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 	if v := ctx.RequestValidators[name]; v != nil {
 		// If this is a get request, then we'd have to assemble
 		// the incoming data from r.Form
-		if method == "get" {
+		hasBody := method != "get" && method != "head"
+		if !hasBody {
 			switch payloadType {
 			case "interface{}", "map[string]interface{}":
 				buf.WriteString("\nif err := r.ParseForm(); err != nil {")
-				buf.WriteString("\nhttpError(w, `Failed to process query/post form`, http.StatusInternalServerError, nil)")
+				buf.WriteString("\nhttpError(w, `Failed to process query/post form`, http.StatusBadRequest, err)")
 				buf.WriteString("\nreturn")
 				buf.WriteString("\n}")
 				buf.WriteString("\npayload := make(map[string]interface{})")
@@ -282,10 +323,7 @@ func makeMethod(ctx *genctx, name string, l *hschema.Link) (string, error) {
 						fmt.Fprintf(&buf, "\nv, err := getInteger(r.Form, %s)", qk)
 						fmt.Fprintf(&buf, `
 if err != nil {
-	msgbuf := getBytesBuffer()
-	releaseBytesBuffer(msgbuf)
-	msgbuf.WriteString("Invalid parameter %s")
-	httpError(w, msgbuf.String(), http.StatusInternalServerError, err)
+	httpError(w, `+"`Invalid parameter %s`"+`, http.StatusBadRequest, err)
 	return
 }
 `, k)
@@ -306,11 +344,18 @@ default:
 			default:
 				buf.WriteString("\nvar payload ")
 				buf.WriteString(strings.TrimPrefix(payloadType, ctx.AppPkg+"."))
+				if t, ok := ctx.GeneratedType(payloadType); ok && t.Query {
+					buf.WriteString("\nif err := payload.UnmarshalQuery(r.URL.Query()); err != nil {")
+					buf.WriteString("\nhttpError(w, `Failed to parse url query string`, http.StatusBadRequest, err)")
+					buf.WriteString("\nreturn")
+					buf.WriteString("\n}")
+					break
+				}
 				buf.WriteString("\nqbuf := getBytesBuffer()")
 				buf.WriteString("\ndefer releaseBytesBuffer(qbuf)")
 				buf.WriteString("\nqbuf.WriteString(r.URL.RawQuery)")
 				buf.WriteString("\nif err := urlenc.Unmarshal(qbuf.Bytes(), &payload); err != nil {")
-				buf.WriteString("\nhttpError(w, `Failed to parse url query string`, http.StatusInternalServerError, err)")
+				buf.WriteString("\nhttpError(w, `Failed to parse url query string`, http.StatusBadRequest, err)")
 				buf.WriteString("\nreturn")
 				buf.WriteString("\n}")
 			}
@@ -318,61 +363,333 @@ default:
 			buf.WriteString("\nvar payload ")
 			buf.WriteString(strings.TrimPrefix(payloadType, ctx.AppPkg+"."))
 
-			buf.WriteString("\njsonbuf := getBytesBuffer()")
-			buf.WriteString("\ndefer releaseBytesBuffer(jsonbuf)")
-			buf.WriteString("\n\nswitch ct := r.Header.Get(\"Content-Type\"); {")
-			buf.WriteString("\ncase ct == \"application/json\":")
-			buf.WriteString("\nif _, err := io.Copy(jsonbuf, io.LimitReader(r.Body, MaxPostSize)); err != nil {")
-			buf.WriteString("\nhttpError(w, `Failed to read request body`, http.StatusInternalServerError, err)")
+			buf.WriteString("\nbody := getBytesBuffer()")
+			buf.WriteString("\ndefer releaseBytesBuffer(body)")
+
+			// The body may be in any media type with a registered codec,
+			// regardless of the encType of the link
+			buf.WriteString("\n\nct, _, err := mime.ParseMediaType(r.Header.Get(\"Content-Type\"))")
+			buf.WriteString("\nif err != nil {")
+			buf.WriteString("\nhttpError(w, `Invalid content-type`, http.StatusUnsupportedMediaType, err)")
+			buf.WriteString("\nreturn")
+			buf.WriteString("\n}")
+			buf.WriteString("\ndec, ok := lookupCodec(codecs, ct)")
+			buf.WriteString("\nswitch {")
+			buf.WriteString("\ncase ok:")
+			// Read one more byte than allowed, so that we can tell if the
+			// body was too large
+			buf.WriteString("\nif _, err := io.Copy(body, io.LimitReader(r.Body, MaxPostSize+1)); err != nil {")
+			buf.WriteString("\nhttpError(w, `Failed to read request body`, http.StatusBadRequest, err)")
+			buf.WriteString("\nreturn")
+			buf.WriteString("\n}")
+			buf.WriteString("\nif body.Len() > MaxPostSize {")
+			buf.WriteString("\nhttpError(w, `Request body too large`, http.StatusRequestEntityTooLarge, nil)")
 			buf.WriteString("\nreturn")
 			buf.WriteString("\n}")
 			// If this is a multipart request, we must extract out the "payload"
 			// field, and treat that as JSON
-			if l.EncType == "multipart/form-data"{
-				buf.WriteString("\ncase strings.HasPrefix(ct, \"multipart/\"):")
+			if l.EncType == "multipart/form-data" {
+				buf.WriteString("\ncase ct == \"multipart/form-data\":")
 				buf.WriteString("\nif err := r.ParseMultipartForm(MaxPostSize); err != nil {")
-				buf.WriteString("\nhttpError(w, `Invalid multipart data`, http.StatusInternalServerError, err)")
+				buf.WriteString("\nhttpError(w, `Invalid multipart data`, http.StatusBadRequest, err)")
 				buf.WriteString("\nreturn")
 				buf.WriteString("\n}")
 				buf.WriteString("\nvals, ok := r.MultipartForm.Value[\"payload\"]")
 				buf.WriteString("\nif ok && len(vals) > 0 {")
-				buf.WriteString("\nif _, err := jsonbuf.WriteString(vals[0]); err != nil {")
-				buf.WriteString("\nhttpError(w, `Failed to read payload`, http.StatusInternalServerError, err)")
+				buf.WriteString("\nif _, err := body.WriteString(vals[0]); err != nil {")
+				buf.WriteString("\nhttpError(w, `Failed to read payload`, http.StatusBadRequest, err)")
 				buf.WriteString("\nreturn")
 				buf.WriteString("\n}")
 				buf.WriteString("\n}")
+				buf.WriteString("\ndec = jsonCodec{}")
 				buf.WriteString("\npayload.MultipartForm = r.MultipartForm")
 			}
 			buf.WriteString("\ndefault:")
-			buf.WriteString("\nhttpError(w, `Invalid content-type`, http.StatusInternalServerError, nil)")
+			buf.WriteString("\nhttpError(w, `Invalid content-type`, http.StatusUnsupportedMediaType, nil)")
 			buf.WriteString("\nreturn")
 			buf.WriteString("\n}")
 
 			buf.WriteString("\nif pdebug.Enabled {")
-			buf.WriteString("\npdebug.Printf(`-----> %s`, jsonbuf.Bytes())")
+			buf.WriteString("\npdebug.Printf(`-----> %s`, body.Bytes())")
 			buf.WriteString("\n}")
-			buf.WriteString("\nif err := json.Unmarshal(jsonbuf.Bytes(), &payload); err != nil {")
-			buf.WriteString("\nhttpError(w, `Invalid JSON input`, http.StatusInternalServerError, err)")
+			fmt.Fprintf(&buf, "\nif err := decodePayload(dec, body.Bytes(), %s.%s, &payload); err != nil {", ctx.ValidatorPkg, v.Name)
+			buf.WriteString("\nif verr, ok := err.(*validationError); ok {")
+			buf.WriteString("\nhttpError(w, `Invalid input (validation failed)`, http.StatusUnprocessableEntity, verr)")
+			buf.WriteString("\nreturn")
+			buf.WriteString("\n}")
+			buf.WriteString("\nhttpError(w, `Invalid request body`, http.StatusBadRequest, err)")
 			buf.WriteString("\nreturn")
 			buf.WriteString("\n}")
 		}
 
-		fmt.Fprintf(&buf, "\n\nif err := %s.%s.Validate(&payload); err != nil {", ctx.ValidatorPkg, v.Name)
-		buf.WriteString("\nhttpError(w, `Invalid input (validation failed)`, http.StatusInternalServerError, err)")
-		buf.WriteString("\nreturn")
-		buf.WriteString("\n}")
+		// Bodies are validated by decodePayload
+		if !hasBody {
+			fmt.Fprintf(&buf, "\n\nif err := %s.%s.Validate(&payload); err != nil {", ctx.ValidatorPkg, v.Name)
+			buf.WriteString("\nhttpError(w, `Invalid input (validation failed)`, http.StatusUnprocessableEntity, &validationError{err: err})")
+			buf.WriteString("\nreturn")
+			buf.WriteString("\n}")
+		}
+	}
+
+	if ctx.Service {
+		writeServiceCall(&buf, ctx, name, l)
+		buf.WriteString("\n}\n")
+		if stream {
+			writeStreamType(&buf, ctx, name, l)
+		}
+		return buf.String(), nil
 	}
 
 	fmt.Fprintf(&buf, "\ndo%s(ctx, w, r", name)
+	for _, p := range ctx.PathParams[name] {
+		buf.WriteString(", ")
+		buf.WriteString(p.GoName)
+	}
 	if _, ok := ctx.RequestValidators[name]; ok {
 		buf.WriteString(`, &payload`)
 	}
 	buf.WriteString(`)`)
 	buf.WriteString("\n}\n")
+	if stream {
+		writeStreamType(&buf, ctx, name, l)
+	}
 
 	return buf.String(), nil
 }
 
+// writeStreamType writes the <Name>Stream type, which writes the elements
+// of a streaming response
+func writeStreamType(buf *bytes.Buffer, ctx *genctx, name string, l *hschema.Link) {
+	typ := strings.TrimPrefix(ctx.ResponsePayloadType[name], ctx.AppPkg+".")
+	if typ == "" {
+		typ = "interface{}"
+	}
+	if genutil.LooksLikeStruct(typ) {
+		typ = "*" + typ
+	}
+	format := "Server-Sent Events"
+	sse := ctx.Stream[name] == parser.StreamSSE
+	if !sse {
+		format = "newline delimited JSON"
+	}
+
+	fmt.Fprintf(buf, "\n// %sStream writes the elements of the response to %s,", name, name)
+	fmt.Fprintf(buf, "\n// as %s", format)
+	fmt.Fprintf(buf, "\ntype %sStream struct {", name)
+	buf.WriteString("\n*streamWriter")
+	buf.WriteString("\n}")
+
+	fmt.Fprintf(buf, "\n\n// New%sStream creates the stream for the response to %s. The", name, name)
+	buf.WriteString("\n// headers are written when the first element is sent, or when Start")
+	buf.WriteString("\n// is called")
+	fmt.Fprintf(buf, "\nfunc New%sStream(w http.ResponseWriter) *%sStream {", name, name)
+	fmt.Fprintf(buf, "\nreturn &%sStream{newStreamWriter(w, %t, %s)}", name, sse, successStatus(ctx, name, l))
+	buf.WriteString("\n}")
+
+	buf.WriteString("\n\n// Send writes v to the client, and flushes it")
+	fmt.Fprintf(buf, "\nfunc (s *%sStream) Send(v %s) error {", name, typ)
+	if v := ctx.ResponseValidators[name]; v != nil {
+		buf.WriteString("\nif ResponseValidation != ResponseValidationOff {")
+		fmt.Fprintf(buf, "\nif err := %s.%s.Validate(v); err != nil {", ctx.ValidatorPkg, v.Name)
+		fmt.Fprintf(buf, "\nlog.Printf(\"Response element for %s failed validation: %%s\", err)", name)
+		buf.WriteString("\nif ResponseValidation == ResponseValidationFail {")
+		buf.WriteString("\nreturn err")
+		buf.WriteString("\n}")
+		buf.WriteString("\n}")
+		buf.WriteString("\n}")
+	}
+	buf.WriteString("\nreturn s.send(v)")
+	buf.WriteString("\n}\n")
+}
+
+// successStatus returns the name of the HTTP status constant used when
+// the Service successfully handles the request
+func successStatus(ctx *genctx, name string, l *hschema.Link) string {
+	if list := ctx.SuccessStatus[name]; len(list) > 0 {
+		return strconv.Itoa(list[0])
+	}
+	if _, ok := ctx.ResponsePayloadType[name]; !ok {
+		return "http.StatusNoContent"
+	}
+	if l.Rel == "create" {
+		return "http.StatusCreated"
+	}
+	return "http.StatusOK"
+}
+
+// serviceResultType returns the type returned by the Service method
+// for the given link, or the empty string if the method only returns
+// an error
+func serviceResultType(ctx *genctx, name string) string {
+	// Streaming links send their results via the stream
+	if _, ok := ctx.Stream[name]; ok {
+		return ""
+	}
+	typ, ok := ctx.ResponsePayloadType[name]
+	if !ok {
+		return ""
+	}
+	typ = strings.TrimPrefix(typ, ctx.AppPkg+".")
+	if genutil.LooksLikeStruct(typ) {
+		return "*" + typ
+	}
+	return typ
+}
+
+// writeServiceSignature writes the signature of the Service method for
+// the given link, e.g. "CreateUser(ctx context.Context, in *model.CreateUserRequest) (*model.CreateUserResponse, error)"
+func writeServiceSignature(buf *bytes.Buffer, ctx *genctx, name string) {
+	fmt.Fprintf(buf, "%s(ctx context.Context", name)
+	for _, p := range ctx.PathParams[name] {
+		fmt.Fprintf(buf, ", %s %s", p.GoName, p.Type)
+	}
+	if _, ok := ctx.RequestValidators[name]; ok {
+		fmt.Fprintf(buf, ", in *%s", strings.TrimPrefix(ctx.RequestPayloadType[name], ctx.AppPkg+"."))
+	}
+	if _, ok := ctx.Stream[name]; ok {
+		fmt.Fprintf(buf, ", stream *%sStream", name)
+	}
+	if typ := serviceResultType(ctx, name); typ != "" {
+		fmt.Fprintf(buf, ") (%s, error)", typ)
+	} else {
+		buf.WriteString(") error")
+	}
+}
+
+// writeServiceCall writes the code to call the Service method for the
+// given link, and to write its result as the response
+func writeServiceCall(buf *bytes.Buffer, ctx *genctx, name string, l *hschema.Link) {
+	buf.WriteString("\n\n")
+
+	// Errors can only be reported until the stream has started
+	if _, ok := ctx.Stream[name]; ok {
+		fmt.Fprintf(buf, "stream := New%sStream(w)", name)
+		fmt.Fprintf(buf, "\nif err := s.svc.%s(ctx", name)
+		for _, p := range ctx.PathParams[name] {
+			buf.WriteString(", ")
+			buf.WriteString(p.GoName)
+		}
+		if _, ok := ctx.RequestValidators[name]; ok {
+			buf.WriteString(", &payload")
+		}
+		buf.WriteString(", stream); err != nil {")
+		buf.WriteString("\nif !stream.started {")
+		buf.WriteString("\nserviceError(w, err)")
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		fmt.Fprintf(buf, "\nlog.Printf(\"Failed to stream response for %s: %%s\", err)", name)
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		buf.WriteString("\nstream.Start()")
+		return
+	}
+
+	// The media type of the response is negotiated before calling the
+	// Service, so that unacceptable requests have no side effects
+	if serviceResultType(ctx, name) != "" {
+		fmt.Fprintf(buf, "mt, codec, ok := negotiate(r.Header.Get(\"Accept\"), %s)", strconv.Quote(ctx.ResponseMediaType[name]))
+		buf.WriteString("\nif !ok {")
+		buf.WriteString("\nhttpError(w, `No acceptable media type`, http.StatusNotAcceptable, nil)")
+		buf.WriteString("\nreturn")
+		buf.WriteString("\n}")
+		buf.WriteString("\nres, err := ")
+	} else {
+		buf.WriteString("if err := ")
+	}
+	fmt.Fprintf(buf, "s.svc.%s(ctx", name)
+	for _, p := range ctx.PathParams[name] {
+		buf.WriteString(", ")
+		buf.WriteString(p.GoName)
+	}
+	if _, ok := ctx.RequestValidators[name]; ok {
+		buf.WriteString(", &payload")
+	}
+	buf.WriteString(")")
+	if serviceResultType(ctx, name) != "" {
+		buf.WriteString("\nif err != nil {")
+	} else {
+		buf.WriteString("; err != nil {")
+	}
+	buf.WriteString("\nserviceError(w, err)")
+	buf.WriteString("\nreturn")
+	buf.WriteString("\n}")
+
+	if serviceResultType(ctx, name) == "" {
+		fmt.Fprintf(buf, "\nw.WriteHeader(%s)", successStatus(ctx, name, l))
+		return
+	}
+	fmt.Fprintf(buf, "\nwriteResponse(w, %s, res, mt, codec)", successStatus(ctx, name, l))
+}
+
+// writeCORSPolicy writes the variable holding the CORS policy for the
+// given link
+func writeCORSPolicy(buf *bytes.Buffer, ctx *genctx, name string) {
+	c := ctx.RequestCORS[name]
+	methods := c.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{ctx.Routes[name].Method}
+	}
+
+	fmt.Fprintf(buf, "\nvar cors%s = corsPolicy{", name)
+	fmt.Fprintf(buf, "\norigins: %s,", stringSliceLiteral(c.AllowOrigins))
+	fmt.Fprintf(buf, "\nmethods: %s,", strconv.Quote(strings.Join(methods, ", ")))
+	if len(c.AllowHeaders) > 0 {
+		fmt.Fprintf(buf, "\nheaders: %s,", strconv.Quote(strings.Join(c.AllowHeaders, ", ")))
+	}
+	if len(c.ExposeHeaders) > 0 {
+		fmt.Fprintf(buf, "\nexpose: %s,", strconv.Quote(strings.Join(c.ExposeHeaders, ", ")))
+	}
+	if c.AllowCredentials {
+		buf.WriteString("\ncredentials: true,")
+	}
+	if c.MaxAge > 0 {
+		fmt.Fprintf(buf, "\nmaxAge: %s,", strconv.Quote(strconv.Itoa(c.MaxAge)))
+	}
+	buf.WriteString("\n}\n")
+}
+
+func stringSliceLiteral(l []string) string {
+	quoted := make([]string, len(l))
+	for i, s := range l {
+		quoted[i] = strconv.Quote(s)
+	}
+	return "[]string{" + strings.Join(quoted, ", ") + "}"
+}
+
+// transportImport returns the import path of the package that holds
+// the generated payload types, or the empty string if the types live
+// in the application package, if the user already imports it, or if
+// none of the types are used. Response types are only considered when
+// responses is true
+func transportImport(ctx *genctx, responses bool) string {
+	if ctx.Types.Len() == 0 || ctx.TransportNs == ctx.AppPkg {
+		return ""
+	}
+
+	if !usesTransportNs(ctx, ctx.RequestPayloadType) && (!responses || !usesTransportNs(ctx, ctx.ResponsePayloadType)) {
+		return ""
+	}
+
+	for _, pkg := range ctx.ServerHints.Imports {
+		if path.Base(pkg) == ctx.TransportNs {
+			return ""
+		}
+	}
+	return path.Join(ctx.PkgPath, ctx.TransportNs)
+}
+
+// usesTransportNs returns true if any of the types, keyed by method name,
+// is qualified with the transport namespace
+func usesTransportNs(ctx *genctx, types map[string]string) bool {
+	prefix := ctx.TransportNs + "."
+	for _, t := range types {
+		if strings.HasPrefix(strings.TrimLeft(t, "*[]"), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func generateFile(ctx *genctx, fn string, cb func(io.Writer, *genctx) error, forceOverwrite bool) error {
 	if _, err := os.Stat(fn); err == nil {
 		if !ctx.Overwrite {
@@ -419,6 +736,13 @@ func generateFiles(ctxif interface{}) error {
 	sysfiles := map[string]func(io.Writer, *genctx) error{
 		filepath.Join(ctx.Dir, fmt.Sprintf("%s_hsup.go", ctx.AppPkg)): generateServerCode,
 	}
+	if ctx.Types.Len() > 0 {
+		if ctx.TransportNs == ctx.AppPkg {
+			sysfiles[filepath.Join(ctx.Dir, "types_hsup.go")] = generateTypesCode
+		} else {
+			sysfiles[filepath.Join(ctx.Dir, ctx.TransportNs, "types_hsup.go")] = generateTypesCode
+		}
+	}
 	for fn, cb := range sysfiles {
 		if err := generateFile(ctx, fn, cb, true); err != nil {
 			return errors.Wrap(err, "failed to generate file '"+fn+"'")
@@ -442,6 +766,10 @@ func generateFiles(ctxif interface{}) error {
 	return nil
 }
 
+func generateTypesCode(out io.Writer, ctx *genctx) error {
+	return ctx.Types.Generate(out, ctx.TransportNs)
+}
+
 func generateExecutableCode(out io.Writer, ctx *genctx) error {
 	buf := bytes.Buffer{}
 	buf.WriteString(`package main` + "\n\n")
@@ -530,7 +858,11 @@ func _main() error {
 
 	log.Printf("Server listening on %s", opts.Listen)
 `)
-	fmt.Fprintf(&buf, `if err := %s.Run(opts.Listen); err != nil {`+"\n", ctx.AppPkg)
+	if ctx.Service {
+		fmt.Fprintf(&buf, `if err := %s.Run(opts.Listen, %s.NewService()); err != nil {`+"\n", ctx.AppPkg, ctx.AppPkg)
+	} else {
+		fmt.Fprintf(&buf, `if err := %s.Run(opts.Listen); err != nil {`+"\n", ctx.AppPkg)
+	}
 	buf.WriteString(` log.Printf("%s", err)
 		return 1
 	}
@@ -551,6 +883,9 @@ func generateStubHandlerCode(out io.Writer, ctx *genctx) error {
 	} else {
 		extlibs = append(extlibs, "golang.org/x/net/context")
 	}
+	if pkg := transportImport(ctx, ctx.Service); pkg != "" {
+		extlibs = append(extlibs, pkg)
+	}
 	genutil.WriteImports(
 		&buf,
 		[]string{
@@ -559,11 +894,34 @@ func generateStubHandlerCode(out io.Writer, ctx *genctx) error {
 		extlibs,
 	)
 
+	if ctx.Service {
+		buf.WriteString("\ntype service struct{}")
+		buf.WriteString("\n\n// NewService creates the Service that handles the requests")
+		buf.WriteString("\nfunc NewService() Service {")
+		buf.WriteString("\nreturn &service{}")
+		buf.WriteString("\n}\n")
+		for _, methodName := range ctx.MethodNames {
+			buf.WriteString("\nfunc (svc *service) ")
+			writeServiceSignature(&buf, ctx, methodName)
+			buf.WriteString(" {")
+			buf.WriteString("\nreturn ")
+			if serviceResultType(ctx, methodName) != "" {
+				buf.WriteString("nil, ")
+			}
+			buf.WriteString("&Error{StatusCode: http.StatusNotImplemented, Message: `Not implemented`}")
+			buf.WriteString("\n}\n")
+		}
+		return genutil.WriteFmtCode(out, &buf)
+	}
+
 	for _, methodName := range ctx.MethodNames {
 		payloadType := ctx.RequestPayloadType[methodName]
 		payloadType = strings.TrimPrefix(payloadType, ctx.AppPkg+".")
 
 		fmt.Fprintf(&buf, "\nfunc do%s(ctx context.Context, w http.ResponseWriter, r *http.Request", methodName)
+		for _, p := range ctx.PathParams[methodName] {
+			fmt.Fprintf(&buf, ", %s %s", p.GoName, p.Type)
+		}
 		if _, ok := ctx.RequestValidators[methodName]; ok {
 			buf.WriteString(`, payload *`)
 			buf.WriteString(payloadType)
@@ -599,23 +957,32 @@ func generateServerCode(out io.Writer, ctx *genctx) error {
 		imports = append(imports, filepath.Join(ctx.PkgPath, "validator"))
 	}
 
+	if pkg := transportImport(ctx, ctx.Service); pkg != "" {
+		imports = append(imports, pkg)
+	}
+
 	if len(ctx.ServerHints.Imports) > 0 {
 		imports = append(imports, ctx.ServerHints.Imports...)
 	}
 
+	stdlibs := []string{
+		"bytes",
+		"encoding/json",
+		"errors",
+		"io",
+		"log",
+		"mime",
+		"net/http",
+		"net/url",
+		"strconv",
+		"strings",
+		"sync",
+	}
+	codecStdlibs, codecExtlibs := genutil.CodecImports(ctx.RequestMediaType, ctx.ResponseMediaType)
 	genutil.WriteImports(
 		&buf,
-		[]string{
-			"bytes",
-			"encoding/json",
-			"io",
-			"net/http",
-			"net/url",
-			"strconv",
-			"strings",
-			"sync",
-		},
-		imports,
+		append(stdlibs, codecStdlibs...),
+		append(imports, codecExtlibs...),
 	)
 
 	buf.WriteString(`
@@ -638,16 +1005,102 @@ func releaseBytesBuffer(buf *bytes.Buffer) {
 	bbPool.Put(buf)
 }
 
-type Server struct {
-	*mux.Router
+`)
+
+	genutil.WriteCodecs(&buf, ctx.RequestMediaType, ctx.ResponseMediaType)
+	genutil.WriteValidationDetails(&buf)
+	buf.WriteString(`
+// codecs maps media types to the codecs used for request and response
+// bodies
+var codecs = defaultCodecs()
+
+// RegisterCodec registers c as the codec for the media type mt, which
+// replaces any existing codec for mt. Request bodies are accepted in any
+// media type with a codec, and responses are encoded in any of them, as
+// negotiated via the Accept header. It must be called before the server
+// starts handling requests
+func RegisterCodec(mt string, c Codec) {
+	codecs[strings.ToLower(mt)] = c
 }
 
-// NewContext creates a cteonxt.Context object from the request.
-// If you are using appengine, for example, you probably want to set this
-// function to something that create a context, and then sets
-// the appengine context to it so it can be referred to later.
-var NewContext func(*http.Request) context.Context = func(r *http.Request) context.Context {
-	return r.Context()
+// acceptRange is a media range of the Accept header
+type acceptRange struct {
+	mt string
+	q  float64
+}
+
+// negotiate selects the media type of the response from the Accept
+// header. The media type of the link is preferred, unless the client
+// asks for another one with a higher quality value. Media types with
+// a quality value of 0 are never selected
+func negotiate(accept, preferred string) (string, Codec, bool) {
+	if strings.TrimSpace(accept) == "" {
+		c, ok := lookupCodec(codecs, preferred)
+		return preferred, c, ok
+	}
+
+	var ranges []acceptRange
+	refused := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			refused[mt] = true
+			continue
+		}
+		ranges = append(ranges, acceptRange{mt: mt, q: q})
+	}
+
+	var best string
+	var bestQ float64
+	for _, r := range ranges {
+		mt := r.mt
+		switch {
+		case mt == "*/*":
+			mt = preferred
+		case strings.HasSuffix(mt, "/*"):
+			if !strings.HasPrefix(preferred, strings.TrimSuffix(mt, "*")) {
+				continue
+			}
+			mt = preferred
+		}
+		if refused[mt] || r.q < bestQ || (r.q == bestQ && mt != preferred) {
+			continue
+		}
+		if _, ok := lookupCodec(codecs, mt); ok {
+			best, bestQ = mt, r.q
+		}
+	}
+	if best == "" {
+		return "", nil, false
+	}
+	c, ok := lookupCodec(codecs, best)
+	return best, c, ok
+}
+
+// contentType returns the Content-Type header for the media type mt
+func contentType(mt string) string {
+	if mt == "` + genutil.MediaTypeJSON + `" || strings.HasSuffix(mt, "+json") {
+		return mt + "; charset=utf-8"
+	}
+	return mt
+}
+`)
+
+	if ctx.Service {
+		generateServiceCode(&buf, ctx)
+	} else {
+		buf.WriteString(`
+type Server struct {
+	*mux.Router
 }
 
 func Run(l string) error {
@@ -662,6 +1115,17 @@ func New() *Server {
 	s.SetupRoutes()
 	return s
 }
+`)
+	}
+
+	buf.WriteString(`
+// NewContext creates a cteonxt.Context object from the request.
+// If you are using appengine, for example, you probably want to set this
+// function to something that create a context, and then sets
+// the appengine context to it so it can be referred to later.
+var NewContext func(*http.Request) context.Context = func(r *http.Request) context.Context {
+	return r.Context()
+}
 
 var httpError func(http.ResponseWriter, string, int, error) = defaultHTTPError
 func defaultHTTPError(w http.ResponseWriter, message string, st int, err error) {
@@ -672,7 +1136,203 @@ func defaultHTTPError(w http.ResponseWriter, message string, st int, err error)
 	    pdebug.Printf("HTTP Error %s: %s", message, err)
 		}
   }
-  http.Error(w, http.StatusText(st), st)
+
+	res := ErrJSON{Error: message}
+	if res.Error == "" {
+		res.Error = http.StatusText(st)
+	}
+	if ve, ok := err.(*validationError); ok {
+		res.Details = ve.Details()
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(st)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ErrJSON is the body of error responses
+type ErrJSON struct {
+	Error   string        `+"`"+`json:"error,omitempty"`+"`"+`
+	Details []ErrorDetail `+"`"+`json:"details,omitempty"`+"`"+`
+}
+
+// ErrorDetail describes a single problem with the request
+type ErrorDetail struct {
+	Pointer string `+"`"+`json:"pointer,omitempty"`+"`"+` // JSON pointer to the offending value
+	Message string `+"`"+`json:"message"`+"`"+`
+}
+
+// validationError is passed to httpError when the request payload
+// fails validation, so that the details can be reported
+type validationError struct {
+	err error
+}
+
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+// Details converts the error from the validator to a list of ErrorDetail
+func (e *validationError) Details() []ErrorDetail {
+	return validationDetails(e.err)
+}
+
+// payloadValidator is implemented by the validators of request payloads
+type payloadValidator interface {
+	Validate(interface{}) error
+}
+
+// decodePayload decodes the request body into payload, and validates it.
+// The body is validated as decoded by the codec, before it is bound to
+// payload, as required properties are not pointers and would otherwise
+// always appear to be present. Form encoded bodies hold nothing but
+// strings, so they are bound first, and then validated instead.
+// Validation failures are reported as a *validationError
+func decodePayload(dec Codec, data []byte, v payloadValidator, payload interface{}) error {
+	if _, ok := dec.(formCodec); ok {
+		if err := dec.Unmarshal(data, payload); err != nil {
+			return err
+		}
+		if err := v.Validate(payload); err != nil {
+			return &validationError{err: err}
+		}
+		return nil
+	}
+
+	var raw interface{}
+	if err := dec.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := v.Validate(raw); err != nil {
+		return &validationError{err: err}
+	}
+
+	// Bind the validated value, along with any defaults that the
+	// validator has filled in
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, payload)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	httpError(w, `+"`Not found`"+`, http.StatusNotFound, nil)
+}
+
+// SetNextLink adds a Link header pointing to the next page of results,
+// for links paginated using the "link" style. The URL is that of the
+// request, with the query parameters in q replacing those of the request
+func SetNextLink(w http.ResponseWriter, r *http.Request, q url.Values) {
+	u := *r.URL
+	params := u.Query()
+	for k, v := range q {
+		params[k] = v
+	}
+	u.RawQuery = params.Encode()
+	w.Header().Add("Link", "<"+u.RequestURI()+`+"`"+`>; rel="next"`+"`"+`)
+}
+
+// corsPolicy holds the CORS policy for a single link
+type corsPolicy struct {
+	origins     []string
+	methods     string
+	headers     string // empty means whatever was requested
+	expose      string
+	credentials bool
+	maxAge      string
+}
+
+// allowOrigin returns the value for the Access-Control-Allow-Origin
+// header, or the empty string if the origin is not allowed
+func (p *corsPolicy) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, o := range p.origins {
+		if o == origin {
+			return origin
+		}
+		if o == "*" {
+			// Browsers refuse "*" for requests with credentials
+			if p.credentials {
+				return origin
+			}
+			return "*"
+		}
+	}
+	return ""
+}
+
+// apply sets the CORS headers for an actual (non-preflight) request
+func (p *corsPolicy) apply(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	origin := p.allowOrigin(r.Header.Get("Origin"))
+	if origin == "" {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if p.expose != "" {
+		h.Set("Access-Control-Expose-Headers", p.expose)
+	}
+}
+
+// preflight handles OPTIONS requests for a path, using the CORS policy
+// of the link that handles the requested method
+func preflight(policies map[string]*corsPolicy, allow string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Allow", allow)
+		h.Add("Vary", "Origin")
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+
+		p, ok := policies[r.Header.Get("Access-Control-Request-Method")]
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		origin := p.allowOrigin(r.Header.Get("Origin"))
+		if origin == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Methods", p.methods)
+		if p.headers != "" {
+			h.Set("Access-Control-Allow-Headers", p.headers)
+		} else if reqh := r.Header.Get("Access-Control-Request-Headers"); reqh != "" {
+			h.Set("Access-Control-Allow-Headers", reqh)
+		}
+		if p.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if p.maxAge != "" {
+			h.Set("Access-Control-Max-Age", p.maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func methodNotAllowed(allowed ...string) http.HandlerFunc {
+	allow := strings.Join(allowed, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		msgbuf := getBytesBuffer()
+		defer releaseBytesBuffer(msgbuf)
+		msgbuf.WriteString("Method was ")
+		msgbuf.WriteString(r.Method)
+		msgbuf.WriteString(", expected one of ")
+		msgbuf.WriteString(allow)
+		httpError(w, msgbuf.String(), http.StatusMethodNotAllowed, nil)
+	})
 }
 
 func getInteger(v url.Values, f string) ([]int64, error) {
@@ -693,6 +1353,173 @@ func getInteger(v url.Values, f string) ([]int64, error) {
 	return ret, nil
 }
 
+// ResponseValidationMode specifies what happens to responses that do
+// not conform to the targetSchema of the link
+type ResponseValidationMode int
+
+const (
+	// ResponseValidationOff disables response validation
+	ResponseValidationOff ResponseValidationMode = iota
+	// ResponseValidationLog logs invalid responses, but sends them as-is
+	ResponseValidationLog
+	// ResponseValidationFail logs invalid responses, and replaces them
+	// with a 500 Internal Server Error
+	ResponseValidationFail
+)
+
+// ResponseValidation controls whether successful responses are
+// validated before being sent. As this requires the response to be
+// buffered and decoded, it is meant for development and staging
+// environments, and is disabled by default
+var ResponseValidation = ResponseValidationOff
+
+type responseValidator interface {
+	Validate(interface{}) error
+}
+
+// validatingResponseWriter buffers the response, so that it can be
+// validated before being written to dst
+type validatingResponseWriter struct {
+	dst    http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *validatingResponseWriter) Header() http.Header {
+	return w.dst.Header()
+}
+
+func (w *validatingResponseWriter) WriteHeader(st int) {
+	if w.status == 0 {
+		w.status = st
+	}
+}
+
+func (w *validatingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *validatingResponseWriter) finish(name string, v responseValidator) {
+	st := w.status
+	if st == 0 {
+		st = http.StatusOK
+	}
+
+	// Only successful responses with a body are described by the
+	// targetSchema
+	if st >= 200 && st < 300 && w.body.Len() > 0 {
+		var payload interface{}
+		err := decodeResponse(w.dst.Header().Get("Content-Type"), w.body.Bytes(), &payload)
+		if err == nil {
+			err = v.Validate(payload)
+		}
+		if err != nil {
+			log.Printf("Response for %s failed validation: %s", name, err)
+			if ResponseValidation == ResponseValidationFail {
+				httpError(w.dst, `+"`Invalid response (validation failed)`"+`, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	}
+
+	w.dst.WriteHeader(st)
+	w.body.WriteTo(w.dst)
+}
+
+// decodeResponse decodes a response body of the given content type into
+// JSON values, i.e. maps, slices, strings, float64s and bools. Responses
+// without a Content-Type are assumed to be JSON
+func decodeResponse(ct string, data []byte, v *interface{}) error {
+	mt := "` + genutil.MediaTypeJSON + `"
+	if ct != "" {
+		var err error
+		if mt, _, err = mime.ParseMediaType(ct); err != nil {
+			return err
+		}
+	}
+	codec, ok := lookupCodec(codecs, mt)
+	if !ok {
+		return errors.New("no codec for content type " + ct)
+	}
+	if err := codec.Unmarshal(data, v); err != nil {
+		return err
+	}
+	if _, ok := codec.(jsonCodec); ok {
+		return nil
+	}
+
+	// Other codecs decode numbers into various types
+	buf, err := json.Marshal(*v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+// streamWriter writes the elements of a streaming response, either as
+// Server-Sent Events or as newline delimited JSON, flushing each element
+// to the client as soon as it is written
+type streamWriter struct {
+	w       http.ResponseWriter
+	sse     bool
+	status  int
+	started bool
+}
+
+func newStreamWriter(w http.ResponseWriter, sse bool, status int) *streamWriter {
+	return &streamWriter{w: w, sse: sse, status: status}
+}
+
+// Start writes the headers of the response, unless they have already
+// been written
+func (s *streamWriter) Start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	ct := "application/x-ndjson"
+	if s.sse {
+		ct = "text/event-stream"
+	}
+	s.w.Header().Set("Content-Type", ct)
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(s.status)
+	s.flush()
+}
+
+func (s *streamWriter) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *streamWriter) send(v interface{}) error {
+	s.Start()
+
+	// encoding/json never emits raw newlines, so the element always
+	// fits in a single line
+	buf := getBytesBuffer()
+	defer releaseBytesBuffer(buf)
+	if s.sse {
+		buf.WriteString("data: ")
+	}
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	if s.sse {
+		buf.WriteByte('\n')
+	}
+	if _, err := buf.WriteTo(s.w); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
 type HandlerWithContext func(context.Context, http.ResponseWriter, *http.Request)
 func httpWithContext(h HandlerWithContext) http.HandlerFunc {
 	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
@@ -712,6 +1539,12 @@ func httpWithContext(h HandlerWithContext) http.HandlerFunc {
 	buf.WriteString("return h\n")
 	buf.WriteString("}\n\n")
 
+	for _, methodName := range ctx.MethodNames {
+		if _, ok := ctx.RequestCORS[methodName]; ok {
+			writeCORSPolicy(&buf, ctx, methodName)
+		}
+	}
+
 	for _, methodName := range ctx.MethodNames {
 		buf.WriteString(ctx.Methods[methodName])
 		buf.WriteString("\n")
@@ -719,6 +1552,7 @@ func httpWithContext(h HandlerWithContext) http.HandlerFunc {
 
 	buf.WriteString("func (s *Server) SetupRoutes() {")
 	buf.WriteString("\nr := s.Router")
+	buf.WriteString("\nr.NotFoundHandler = http.HandlerFunc(notFound)")
 
 	paths := make([]string, 0, len(ctx.PathToMethods))
 	for path := range ctx.PathToMethods {
@@ -726,15 +1560,54 @@ func httpWithContext(h HandlerWithContext) http.HandlerFunc {
 	}
 	sort.Strings(paths)
 	for _, path := range paths {
-		method := ctx.PathToMethods[path]
+		methods := ctx.PathToMethods[path]
+		allowed := make([]string, 0, len(methods))
+		for method := range methods {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
 
-		fmt.Fprintf(&buf, "\nr.HandleFunc(`%s`, httpWithContext(", path)
-		for _, w := range ctx.MethodWrappers[method] {
-			fmt.Fprintf(&buf, "%s(", w)
+		for _, method := range allowed {
+			methodName := methods[method]
+			fmt.Fprintf(&buf, "\nr.HandleFunc(`%s`, httpWithContext(", path)
+			for _, w := range ctx.MethodWrappers[methodName] {
+				fmt.Fprintf(&buf, "%s(", w)
+			}
+			if ctx.Service {
+				buf.WriteString("s.")
+			}
+			fmt.Fprintf(&buf, "http%s", methodName)
+			for range ctx.MethodWrappers[methodName] {
+				buf.WriteString(")")
+			}
+			fmt.Fprintf(&buf, ")).Methods(`%s`)", method)
+		}
+
+		// Answer CORS preflight requests for the links on this path that
+		// have a CORS policy, unless OPTIONS is handled by a link
+		var cors []string
+		for _, method := range allowed {
+			if _, ok := ctx.RequestCORS[methods[method]]; ok {
+				cors = append(cors, method)
+			}
 		}
-		fmt.Fprintf(&buf, "http%s", method)
-		for range ctx.MethodWrappers[method] {
-			buf.WriteString(")")
+		if _, ok := methods["OPTIONS"]; !ok && len(cors) > 0 {
+			allowed = append(allowed, "OPTIONS")
+			sort.Strings(allowed)
+			fmt.Fprintf(&buf, "\nr.HandleFunc(`%s`, preflight(map[string]*corsPolicy{", path)
+			for _, method := range cors {
+				fmt.Fprintf(&buf, "\n`%s`: &cors%s,", method, methods[method])
+			}
+			fmt.Fprintf(&buf, "\n}, `%s`)).Methods(`OPTIONS`)", strings.Join(allowed, ", "))
+		}
+
+		// Anything that did not match the above routes gets a 405
+		fmt.Fprintf(&buf, "\nr.HandleFunc(`%s`, methodNotAllowed(", path)
+		for i, method := range allowed {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			fmt.Fprintf(&buf, "`%s`", method)
 		}
 		buf.WriteString("))")
 	}
@@ -744,6 +1617,100 @@ func httpWithContext(h HandlerWithContext) http.HandlerFunc {
 	return genutil.WriteFmtCode(out, &buf)
 }
 
+// generateServiceCode generates the Service interface, and the Server
+// that dispatches requests to it
+func generateServiceCode(buf *bytes.Buffer, ctx *genctx) {
+	buf.WriteString("\n// Service is implemented by the application to handle the requests.")
+	buf.WriteString("\n// The Server takes care of decoding and validating the request payloads,")
+	buf.WriteString("\n// and encoding the results (or errors) as responses")
+	buf.WriteString("\ntype Service interface {")
+	for _, methodName := range ctx.MethodNames {
+		buf.WriteString("\n")
+		writeServiceSignature(buf, ctx, methodName)
+	}
+	buf.WriteString("\n}\n")
+
+	buf.WriteString(`
+type Server struct {
+	*mux.Router
+	svc Service
+}
+
+func Run(l string, svc Service) error {
+	s := New(svc)
+	return http.ListenAndServe(l, s.makeHandler())
+}
+
+func New(svc Service) *Server {
+	s := &Server{
+		Router: mux.NewRouter(),
+		svc:    svc,
+	}
+	s.SetupRoutes()
+	return s
+}
+
+// Error may be returned by Service methods to control the HTTP status
+// code of the response. Any other error results in a 500
+type Error struct {
+	StatusCode int
+	Message    string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+// Cause returns the underlying error, if any
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// serviceError translates errors returned from the Service to HTTP
+// responses. Errors are unwrapped via their Cause() method until an
+// *Error is found
+func serviceError(w http.ResponseWriter, err error) {
+	st := http.StatusInternalServerError
+	message := http.StatusText(st)
+	for e := err; e != nil; {
+		if se, ok := e.(*Error); ok {
+			st = se.StatusCode
+			message = se.Message
+			break
+		}
+
+		c, ok := e.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		e = c.Cause()
+	}
+	httpError(w, message, st, err)
+}
+
+// writeResponse writes v as the response with the given status code,
+// encoded by codec as the media type mt
+func writeResponse(w http.ResponseWriter, st int, v interface{}, mt string, codec Codec) {
+	buf, err := codec.Marshal(v)
+	if err != nil {
+		httpError(w, ` + "`Failed to encode response`" + `, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType(mt))
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(st)
+	w.Write(buf)
+}
+`)
+}
+
 func generateDataCode(out io.Writer, ctx *genctx) error {
 	buf := bytes.Buffer{}
 	fmt.Fprintf(&buf, `package %s`+"\n\n", ctx.AppPkg)
@@ -758,6 +1725,12 @@ func generateDataCode(out io.Writer, ctx *genctx) error {
 
 	for t := range types {
 		if i := strings.IndexRune(t, '.'); i > -1 { // we have a qualified struct name?
+			// Types in the transport namespace are generated in types_hsup.go
+			if t[:i] == ctx.TransportNs {
+				if _, ok := ctx.Types.Lookup(t[i+1:]); ok {
+					continue
+				}
+			}
 			if prefix := t[:i+1]; prefix != "" {
 				if prefix != ctx.AppPkg+"." {
 					log.Printf(" * '%s' has a package name that's not the app package (%s != %s.)", t, prefix, ctx.AppPkg)
@@ -785,8 +1758,22 @@ func generateTestCode(out io.Writer, ctx *genctx) error {
 		"github.com/stretchr/testify/assert",
 	}
 
-	if len(ctx.ResponseValidators) > 0 {
-		imports = append(imports, filepath.Join(ctx.PkgPath, ctx.ValidatorPkg))
+	// Elements of streaming responses are validated by the client
+	for name := range ctx.ResponseValidators {
+		if _, ok := ctx.Stream[name]; !ok {
+			imports = append(imports, filepath.Join(ctx.PkgPath, ctx.ValidatorPkg))
+			break
+		}
+	}
+
+	if pkg := transportImport(ctx, false); pkg != "" {
+		imports = append(imports, pkg)
+	}
+
+	if genutil.VersionCompare(ctx.GoVersion, "1.7") >= 0 {
+		imports = append(imports, "context")
+	} else {
+		imports = append(imports, "golang.org/x/net/context")
 	}
 
 	genutil.WriteImports(
@@ -798,14 +1785,28 @@ func generateTestCode(out io.Writer, ctx *genctx) error {
 		imports,
 	)
 
+	// MethodNames is sorted, so links are looked up by name
+	links := make(map[string]*hschema.Link, len(ctx.Schema.Links))
+	for _, l := range ctx.Schema.Links {
+		links[genutil.TitleToName(l.Title)] = l
+	}
+
 	for _, methodName := range ctx.MethodNames {
 		fmt.Fprintf(&buf, "func Test%s(t *testing.T) {\n", methodName)
-		fmt.Fprintf(&buf, "ts := httptest.NewServer(%s.New())\n", ctx.AppPkg)
+		if ctx.Service {
+			fmt.Fprintf(&buf, "ts := httptest.NewServer(%s.New(%s.NewService()))\n", ctx.AppPkg, ctx.AppPkg)
+		} else {
+			fmt.Fprintf(&buf, "ts := httptest.NewServer(%s.New())\n", ctx.AppPkg)
+		}
 		buf.WriteString(`defer ts.Close()
 
 `)
 		fmt.Fprintf(&buf, "cl := %s.New(ts.URL)\n", ctx.ClientPkg)
 
+		for _, p := range ctx.PathParams[methodName] {
+			fmt.Fprintf(&buf, "var %s %s\n", p.GoName, p.Type)
+		}
+
 		if pt, ok := ctx.RequestPayloadType[methodName]; ok {
 			buf.WriteString("var in ")
 			if genutil.LooksLikeStruct(pt) {
@@ -814,19 +1815,38 @@ func generateTestCode(out io.Writer, ctx *genctx) error {
 			buf.WriteString(pt)
 			buf.WriteString("\n")
 		}
-		if _, ok := ctx.ResponsePayloadType[methodName]; ok {
+		// The client ignores the targetSchema for HEAD requests
+		_, hasResponse := ctx.ResponsePayloadType[methodName]
+		if ctx.Routes[methodName].Method == "HEAD" {
+			hasResponse = false
+		}
+		if hasResponse {
 			buf.WriteString("res, ")
 		}
 
 		fmt.Fprintf(&buf, "err := cl.%s(", methodName)
+		args := make([]string, 0, len(ctx.PathParams[methodName])+2)
+		args = append(args, "context.Background()")
+		for _, p := range ctx.PathParams[methodName] {
+			args = append(args, p.GoName)
+		}
 		if _, ok := ctx.RequestPayloadType[methodName]; ok {
-			buf.WriteString("in")
+			args = append(args, "in")
+		}
+		// Multipart links also take the files to upload
+		if l := links[methodName]; l.EncType == "multipart/form-data" || l.Extras[ext.MultipartFilesKey] != nil {
+			args = append(args, "nil")
 		}
+		buf.WriteString(strings.Join(args, ", "))
 		buf.WriteString(")\n")
 		fmt.Fprintf(&buf, `if !assert.NoError(t, err, "%s should succeed") {`+"\n", methodName)
 		buf.WriteString("return\n")
 		buf.WriteString("}\n")
-		if _, ok := ctx.ResponseValidators[methodName]; ok {
+		if _, ok := ctx.Stream[methodName]; ok {
+			buf.WriteString("defer res.Close()\n")
+			hasResponse = false
+		}
+		if _, ok := ctx.ResponseValidators[methodName]; ok && hasResponse {
 			fmt.Fprintf(&buf, `if !assert.NoError(t, %s.HTTP%sResponse.Validate(&res), "Validation should succeed") {`+"\n", ctx.ValidatorPkg, methodName)
 			buf.WriteString("return\n}\n")
 		}
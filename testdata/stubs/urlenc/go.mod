@@ -0,0 +1 @@
+module github.com/lestrrat-go/urlenc
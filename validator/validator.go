@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/lestrrat-go/hsup"
@@ -137,6 +139,57 @@ func generateFile(ctx *genctx, fn string, cb func(io.Writer, *genctx) error) err
 	return cb(f, ctx)
 }
 
+// property is the validator for a single property of a request payload
+type property struct {
+	Name      string
+	Required  bool
+	Validator *jsval.JSVal
+}
+
+// propertyValidators creates validators for each of the properties of
+// the request payloads, keyed by the name of the payload validator
+func propertyValidators(ctx *genctx) (map[string][]property, error) {
+	props := make(map[string][]property)
+	for _, link := range ctx.Schema.Links {
+		if link.Schema == nil {
+			continue
+		}
+		v, ok := ctx.RequestValidators[genutil.TitleToName(link.Title)]
+		if !ok {
+			continue
+		}
+
+		ls := link.Schema
+		if !ls.IsResolved() {
+			rs, err := ls.Resolve(ctx.Schema)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to resolve schema (request)")
+			}
+			ls = rs
+		}
+
+		pnames := make([]string, 0, len(ls.Properties))
+		for pname := range ls.Properties {
+			pnames = append(pnames, pname)
+		}
+		sort.Strings(pnames)
+
+		for j, pname := range pnames {
+			pv, err := genutil.MakeValidator(ls.Properties[pname], ctx.Schema)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create validator for property '%s'", pname)
+			}
+			pv.Name = fmt.Sprintf("prop%s%d", v.Name, j)
+			props[v.Name] = append(props[v.Name], property{
+				Name:      pname,
+				Required:  ls.IsPropRequired(pname),
+				Validator: pv,
+			})
+		}
+	}
+	return props, nil
+}
+
 func generateValidatorCode(out io.Writer, ctx *genctx) error {
 	g := jsval.NewGenerator()
 	validators := make([]*jsval.JSVal, 0, len(ctx.RequestValidators)+len(ctx.ResponseValidators))
@@ -147,13 +200,30 @@ func generateValidatorCode(out io.Writer, ctx *genctx) error {
 		validators = append(validators, v)
 	}
 
+	props, err := propertyValidators(ctx)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(props))
+	for name, l := range props {
+		names = append(names, name)
+		for _, p := range l {
+			validators = append(validators, p.Validator)
+		}
+	}
+	sort.Strings(names)
+
 	buf := bytes.Buffer{}
 	genutil.WriteDoNotEdit(&buf)
 	buf.WriteString("package " + ctx.ValidatorPkg + "\n\n")
 
+	var stdlibs []string
+	if len(props) > 0 {
+		stdlibs = []string{"encoding/json", "errors", "strings"}
+	}
 	genutil.WriteImports(
 		&buf,
-		nil,
+		stdlibs,
 		[]string{
 			"github.com/lestrrat-go/jsval",
 		},
@@ -163,5 +233,91 @@ func generateValidatorCode(out io.Writer, ctx *genctx) error {
 	}
 	buf.WriteString("\n\n")
 
+	if len(props) == 0 {
+		return genutil.WriteFmtCode(out, &buf)
+	}
+
+	buf.WriteString(`
+// property validates a single property of a request payload
+type property struct {
+	name      string
+	required  bool
+	validator *jsval.JSVal
+}
+
+// allProperties replaces the root constraint of the validators for
+// request payloads. When the payload is invalid, each property is
+// validated on its own, so that all of the invalid properties are
+// reported instead of only the first one
+type allProperties struct {
+	jsval.Constraint
+	props []property
+}
+
+// Errors holds an error for each invalid property of a request payload
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Errors returns the errors for each invalid property
+func (e Errors) Errors() []error {
+	return e
+}
+
+func (c *allProperties) Validate(v interface{}) error {
+	err := c.Constraint.Validate(v)
+	if err == nil {
+		return nil
+	}
+
+	// Values other than decoded JSON, such as structs, are looked at
+	// through their JSON representation
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		b, jerr := json.Marshal(v)
+		if jerr != nil || json.Unmarshal(b, &m) != nil || m == nil {
+			return err
+		}
+	}
+
+	var errs Errors
+	for _, p := range c.props {
+		pv, ok := m[p.name]
+		if !ok {
+			if p.required {
+				errs = append(errs, errors.New("object property '"+p.name+"' is required"))
+			}
+			continue
+		}
+		if perr := p.validator.Root().Validate(pv); perr != nil {
+			errs = append(errs, errors.New("object property '"+p.name+"' validation failed: "+perr.Error()))
+		}
+	}
+	if len(errs) < 2 {
+		return err
+	}
+	return errs
+}
+`)
+
+	buf.WriteString("\nfunc init() {")
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\n%s.SetRoot(&allProperties{", name)
+		fmt.Fprintf(&buf, "\nConstraint: %s.Root(),", name)
+		buf.WriteString("\nprops: []property{")
+		for _, p := range props[name] {
+			fmt.Fprintf(&buf, "\n{name: %s, required: %t, validator: %s},", strconv.Quote(p.Name), p.Required, p.Validator.Name)
+		}
+		buf.WriteString("\n},")
+		buf.WriteString("\n})")
+	}
+	buf.WriteString("\n}\n")
+
 	return genutil.WriteFmtCode(out, &buf)
 }